@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clfTimeFormat is the [day/month/year:hour:minute:second zone] stamp used by Apache and NGINX
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// rotatedTimeFormat sorts lexically in the same order as time, which is how we find the oldest backups
+const rotatedTimeFormat = "20060102T150405.000000000"
+
+// middlewareAccessLog writes one Combined Log Format line per request to w
+// https://httpd.apache.org/docs/current/logs.html#combined
+func middlewareAccessLog(w io.Writer) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			lw := &loggingResponseWriter{ResponseWriter: rw}
+			next.ServeHTTP(lw, r)
+
+			// (!) a failing access log must never fail the request so the error is only reported
+			_, err := io.WriteString(w, combinedLogLine(r, lw.Status(), lw.bytes, start))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error writing access log: %s\n", err)
+			}
+		})
+	}
+}
+
+// combinedLogLine formats a request as `host ident user [time] "request" status bytes "referer" "user-agent"`
+func combinedLogLine(r *http.Request, status int, bytes int64, start time.Time) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	user := "-"
+	if username, _, ok := r.BasicAuth(); ok && username != "" {
+		user = username
+	}
+
+	size := "-"
+	if bytes > 0 {
+		size = strconv.FormatInt(bytes, 10)
+	}
+
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		clfField(host),
+		clfField(user),
+		start.Format(clfTimeFormat),
+		clfEscape(r.Method),
+		clfEscape(r.URL.RequestURI()),
+		clfEscape(r.Proto),
+		status,
+		size,
+		clfEscape(r.Referer()),
+		clfEscape(r.UserAgent()),
+	)
+}
+
+// clfField is used for the unquoted fields, which are "-" when empty
+func clfField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return clfEscape(s)
+}
+
+// clfEscape escapes quotes, backslashes and control characters the way NGINX does
+// so a client can't break a line apart
+func clfEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\' || c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\x%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// rotatingFile is an io.WriteCloser that appends to path and rotates it
+// once it grows past maxSize bytes or every interval, keeping at most maxBackups old files
+// a zero maxSize, interval or maxBackups disables that limit
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	interval   time.Duration
+	maxBackups int
+
+	mux      sync.Mutex
+	file     *os.File
+	size     int64
+	rotateAt time.Time
+	now      func() time.Time
+}
+
+// newRotatingFile opens (or creates) path for appending
+func newRotatingFile(path string, maxSize int64, interval time.Duration, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		interval:   interval,
+		maxBackups: maxBackups,
+		now:        time.Now,
+	}
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mux.Lock()
+	defer rf.mux.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	// (!) a failed rotation keeps appending to the current file so the line isn't lost,
+	// the next write tries to rotate again
+	var rotateErr error
+	if rf.shouldRotate(int64(len(p))) {
+		rotateErr = rf.rotate()
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rotateErr
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mux.Lock()
+	defer rf.mux.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func (rf *rotatingFile) shouldRotate(incoming int64) bool {
+	// (!) never rotate an empty file, a single line bigger than maxSize would otherwise rotate forever
+	if rf.size == 0 {
+		return false
+	}
+	if rf.maxSize > 0 && rf.size+incoming > rf.maxSize {
+		return true
+	}
+	if rf.interval > 0 && !rf.now().Before(rf.rotateAt) {
+		return true
+	}
+	return false
+}
+
+func (rf *rotatingFile) open() error {
+	err := os.MkdirAll(filepath.Dir(rf.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, size, err := openAppend(rf.path)
+	if err != nil {
+		return err
+	}
+
+	rf.file = file
+	rf.size = size
+	rf.scheduleRotation()
+	return nil
+}
+
+func (rf *rotatingFile) scheduleRotation() {
+	if rf.interval > 0 {
+		rf.rotateAt = rf.now().Truncate(rf.interval).Add(rf.interval)
+	}
+}
+
+// openAppend opens (or creates) path for appending and returns its current size
+func openAppend(path string) (*os.File, int64, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// rotate renames the current file to path.<timestamp>, reopens path and prunes old backups
+// the current file stays open until the new one is, so a failure leaves the log writable
+func (rf *rotatingFile) rotate() error {
+	backup := rf.path + "." + rf.now().Format(rotatedTimeFormat)
+	err := os.Rename(rf.path, backup)
+	if err != nil {
+		return err
+	}
+
+	file, size, err := openAppend(rf.path)
+	if err != nil {
+		// (!) put the file back so the one we keep writing to is still at path
+		return errors.Join(err, os.Rename(backup, rf.path))
+	}
+
+	err = rf.file.Close()
+	rf.file = file
+	rf.size = size
+	rf.scheduleRotation()
+	if err != nil {
+		return err
+	}
+
+	return rf.prune()
+}
+
+func (rf *rotatingFile) prune() error {
+	if rf.maxBackups <= 0 {
+		return nil
+	}
+
+	backups, err := rf.backups()
+	if err != nil {
+		return err
+	}
+	if len(backups) <= rf.maxBackups {
+		return nil
+	}
+
+	for _, backup := range backups[:len(backups)-rf.maxBackups] {
+		err := os.Remove(backup)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backups returns the rotated files for path, oldest first
+func (rf *rotatingFile) backups() ([]string, error) {
+	matches, err := filepath.Glob(rf.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]string, 0, len(matches))
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, rf.path+".")
+		_, err := time.Parse(rotatedTimeFormat, suffix)
+		if err == nil {
+			backups = append(backups, match)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareAccessLog(t *testing.T) {
+	var accessLog bytes.Buffer
+	handler := middlewareAccessLog(&accessLog)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":1}`))
+	}))
+
+	request := httptest.NewRequest("POST", "/api/chirps?x=1", nil)
+	request.RemoteAddr = "203.0.113.7:51234"
+	request.Header.Set("Referer", "http://example.com/")
+	request.Header.Set("User-Agent", `evil "agent"`)
+	handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	pattern := regexp.MustCompile(`^203\.0\.113\.7 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "POST /api/chirps\?x=1 HTTP/1\.1" 201 8 "http://example.com/" "evil \\x22agent\\x22"\n$`)
+	if !pattern.MatchString(accessLog.String()) {
+		t.Errorf("unexpected combined log line %q", accessLog.String())
+	}
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	rf, err := newRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	// (!) the clock only moves forward so every rotated file gets a unique name
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	rf.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	for i := 0; i < 5; i++ {
+		_, err := rf.Write([]byte("12345678\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	backups, err := rf.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Errorf("expected %d backups | got %d: %v", 2, len(backups), backups)
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(current) != "12345678\n" {
+		t.Errorf("expected the current file to hold only the last line | got %q", current)
+	}
+}
+
+func TestRotatingFileInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+
+	clock := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+	rf := &rotatingFile{
+		path:     path,
+		interval: 24 * time.Hour,
+		now:      func() time.Time { return clock },
+	}
+	err := rf.open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	rf.Write([]byte("day one\n"))
+	clock = clock.Add(2 * time.Hour)
+	rf.Write([]byte("day two\n"))
+
+	backups, err := rf.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("expected %d backup | got %d", 1, len(backups))
+	}
+
+	rotated, err := os.ReadFile(backups[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(rotated), "day one") {
+		t.Errorf("expected the rotated file to hold day one | got %q", rotated)
+	}
+}
+
+func TestRotatingFileRotateFails(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := map[string]func(t *testing.T, dir, path string){
+		"read-only directory": func(t *testing.T, dir, path string) {
+			err := os.Chmod(dir, 0555)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.Chmod(dir, 0755) })
+
+			// (!) root ignores directory permissions
+			probe, err := os.Create(filepath.Join(dir, "probe"))
+			if err == nil {
+				probe.Close()
+				t.Skip("the directory is still writable, probably running as root")
+			}
+		},
+		"backup name taken by a directory": func(t *testing.T, dir, path string) {
+			err := os.MkdirAll(filepath.Join(path+"."+clock.Format(rotatedTimeFormat), "taken"), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+
+	for name, breakRotation := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "logs")
+			path := filepath.Join(dir, "access.log")
+
+			rf, err := newRotatingFile(path, 10, 0, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rf.Close()
+			rf.now = func() time.Time { return clock }
+
+			_, err = rf.Write([]byte("12345678\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			breakRotation(t, dir, path)
+
+			_, err = rf.Write([]byte("rotation failed\n"))
+			if err == nil {
+				t.Error("expected the failed rotation to be reported")
+			}
+			_, err = rf.Write([]byte("still writing\n"))
+			if err == nil {
+				t.Error("expected the rotation to be retried and fail again")
+			}
+
+			current, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := "12345678\nrotation failed\nstill writing\n"
+			if string(current) != expected {
+				t.Errorf("expected every line in the current file %q | got %q", expected, current)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupInfo describes a snapshot written by backupManager.create
+type backupInfo struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Gzip      bool      `json:"gzip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// backupTooSoonError is returned by create when the newest backup is younger than minInterval
+type backupTooSoonError struct {
+	retryAfter time.Duration
+}
+
+func (err backupTooSoonError) Error() string {
+	return fmt.Sprintf("the last backup is too recent, try again in %s", err.retryAfter)
+}
+
+// backupManager writes timestamped snapshots of db to dir and keeps the newest keep of them
+// (!) at most one every minInterval, otherwise a burst of requests could prune away every older backup
+type backupManager struct {
+	db          *DB
+	dir         string
+	gzip        bool
+	keep        int
+	minInterval time.Duration
+	now         func() time.Time
+
+	// mux makes create one at a time so two requests can't both slip past minInterval
+	mux sync.Mutex
+}
+
+func newBackupManager(db *DB, appConfig Config) *backupManager {
+	return &backupManager{
+		db:          db,
+		dir:         appConfig.BackupDir,
+		gzip:        appConfig.BackupGzip,
+		keep:        appConfig.BackupKeep,
+		minInterval: appConfig.BackupMinInterval.Duration,
+		now:         time.Now,
+	}
+}
+
+// prefix is what every backup name starts with, "database-" for database.json
+func (bm *backupManager) prefix() string {
+	base := filepath.Base(bm.db.path)
+	return strings.TrimSuffix(base, filepath.Ext(base)) + "-"
+}
+
+// create snapshots the database, the file only appears under its final name once it's complete
+func (bm *backupManager) create(compress bool) (backupInfo, error) {
+	bm.mux.Lock()
+	defer bm.mux.Unlock()
+
+	err := os.MkdirAll(bm.dir, 0700)
+	if err != nil {
+		return backupInfo{}, err
+	}
+
+	createdAt := bm.now().UTC()
+	if bm.minInterval > 0 {
+		last, err := bm.lastCreated()
+		if err != nil {
+			return backupInfo{}, err
+		}
+		wait := last.Add(bm.minInterval).Sub(createdAt)
+		if wait > 0 {
+			return backupInfo{}, backupTooSoonError{retryAfter: wait}
+		}
+	}
+	name := bm.prefix() + createdAt.Format(rotatedTimeFormat) + ".json"
+	if compress {
+		name += ".gz"
+	}
+
+	// (!) a dotfile so a half written snapshot is never mistaken for a backup
+	tmpFile, err := os.CreateTemp(bm.dir, ".backup-tmp-*")
+	if err != nil {
+		return backupInfo{}, err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	err = bm.write(tmpFile, compress)
+	if err != nil {
+		tmpFile.Close()
+		return backupInfo{}, err
+	}
+	err = tmpFile.Sync()
+	if err != nil {
+		tmpFile.Close()
+		return backupInfo{}, err
+	}
+	info, err := tmpFile.Stat()
+	if err != nil {
+		tmpFile.Close()
+		return backupInfo{}, err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return backupInfo{}, err
+	}
+
+	path := filepath.Join(bm.dir, name)
+	err = os.Rename(tmpFile.Name(), path)
+	if err != nil {
+		return backupInfo{}, err
+	}
+
+	err = bm.prune()
+	if err != nil {
+		return backupInfo{}, fmt.Errorf("pruning old backups: %w", err)
+	}
+	return backupInfo{Path: path, Size: info.Size(), Gzip: compress, CreatedAt: createdAt}, nil
+}
+
+func (bm *backupManager) write(w io.Writer, compress bool) error {
+	if !compress {
+		return bm.db.Snapshot(w)
+	}
+	gzipWriter := gzip.NewWriter(w)
+	err := bm.db.Snapshot(gzipWriter)
+	if err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// backups lists the snapshots in dir, oldest first
+func (bm *backupManager) backups() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(bm.dir, bm.prefix()+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]string, 0, len(matches))
+	for _, match := range matches {
+		_, ok := bm.createdAt(match)
+		if ok {
+			backups = append(backups, match)
+		}
+	}
+	// (!) the compressed and plain backups sort together because the timestamp comes first
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// createdAt reads the time a backup was taken from its name
+func (bm *backupManager) createdAt(path string) (time.Time, bool) {
+	suffix := strings.TrimPrefix(filepath.Base(path), bm.prefix())
+	suffix = strings.TrimSuffix(strings.TrimSuffix(suffix, ".gz"), ".json")
+	createdAt, err := time.Parse(rotatedTimeFormat, suffix)
+	return createdAt, err == nil
+}
+
+// lastCreated is when the newest backup was taken, the zero time when there are none
+func (bm *backupManager) lastCreated() (time.Time, error) {
+	backups, err := bm.backups()
+	if err != nil || len(backups) == 0 {
+		return time.Time{}, err
+	}
+	createdAt, _ := bm.createdAt(backups[len(backups)-1])
+	return createdAt, nil
+}
+
+// prune deletes the oldest backups beyond keep
+func (bm *backupManager) prune() error {
+	if bm.keep <= 0 {
+		return nil
+	}
+	backups, err := bm.backups()
+	if err != nil {
+		return err
+	}
+	if len(backups) <= bm.keep {
+		return nil
+	}
+	for _, backup := range backups[:len(backups)-bm.keep] {
+		err = os.Remove(backup)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handlerBackup snapshots the database, ?gzip=true|false overrides the configured compression
+func (cfg *apiConfig) handlerBackup(w http.ResponseWriter, r *http.Request) {
+	compress := cfg.backups.gzip
+	if value := r.URL.Query().Get("gzip"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid gzip value %q", value))
+			return
+		}
+		compress = parsed
+	}
+
+	info, err := cfg.backups.create(compress)
+	var tooSoon backupTooSoonError
+	if errors.As(err, &tooSoon) {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(tooSoon.retryAfter)))
+		respondWithError(w, http.StatusTooManyRequests, fmt.Sprintf("A backup was taken less than %s ago", cfg.backups.minInterval))
+		return
+	}
+	if err != nil {
+		log.Printf("error creating backup: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create the backup")
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, info)
+}
+
+// readSnapshot reads a backup, gzipped or not, and checks it holds a valid database
+// backups of an encrypted database are encrypted too and need one of its keys
+func readSnapshot(path string, keys *dbKeys) (DBStructure, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	defer file.Close()
+
+	bufReader := bufio.NewReader(file)
+	var reader io.Reader = bufReader
+	// (!) trust the content rather than the file name, gzip files start with 0x1f 0x8b
+	magic, err := bufReader.Peek(2)
+	if err == nil && bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gzipReader, err := gzip.NewReader(bufReader)
+		if err != nil {
+			return DBStructure{}, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	byteData, err := io.ReadAll(reader)
+	if err != nil {
+		return DBStructure{}, err
+	}
+	byteData, err = keys.decrypt(byteData)
+	if err != nil {
+		return DBStructure{}, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// (!) verify before migrating, migrating reseals the checksum
+	err = verifyData(byteData)
+	if err != nil {
+		return DBStructure{}, fmt.Errorf("%s: %w", path, err)
+	}
+	// a backup taken by an older version is upgraded in memory, the file itself is left alone
+	byteData, _, err = migrateData(byteData)
+	if err != nil {
+		return DBStructure{}, fmt.Errorf("%s is not a database snapshot: %w", path, err)
+	}
+
+	dbStructure := DBStructure{}
+	err = json.Unmarshal(byteData, &dbStructure)
+	if err != nil {
+		return DBStructure{}, fmt.Errorf("%s is not a database snapshot: %w", path, err)
+	}
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = make(map[int]Chirp)
+	}
+
+	var errs []error
+	for id, chirp := range dbStructure.Chirps {
+		if chirp.ID != id {
+			errs = append(errs, fmt.Errorf("chirp %d is stored under id %d", chirp.ID, id))
+		}
+		err := validateChirp(chirp.Body)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chirp %d: %w", id, err))
+		}
+	}
+	if len(errs) > 0 {
+		return DBStructure{}, errors.Join(errs...)
+	}
+	return dbStructure, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerBackup(t *testing.T) {
+	t.Parallel()
+
+	appConfig := newTestConfig(t)
+	appConfig.BackupMinInterval = Duration{0}
+	testServer := newTestServer(t, appConfig)
+	client := testServer.Client()
+
+	response, err := client.Post(testServer.URL+"/api/chirps", "application/json", strings.NewReader(`{"body":"backed up"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	for _, query := range []string{"", "?gzip=false"} {
+		t.Run(query, func(t *testing.T) {
+			response := adminRequest(t, client, "POST", testServer.URL+"/api/admin/backup"+query)
+			defer response.Body.Close()
+			if response.StatusCode != http.StatusCreated {
+				t.Fatalf("expected status code %d | got %d", http.StatusCreated, response.StatusCode)
+			}
+
+			info := backupInfo{}
+			err = json.NewDecoder(response.Body).Decode(&info)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Gzip != (query == "") || info.Gzip != strings.HasSuffix(info.Path, ".json.gz") {
+				t.Errorf("expected gzip %t | got %+v", query == "", info)
+			}
+
+			dbStructure, err := readSnapshot(info.Path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := map[int]Chirp{1: {ID: 1, Body: "backed up"}}
+			if !reflect.DeepEqual(dbStructure.Chirps, expected) {
+				t.Errorf("expected %v | got %v", expected, dbStructure.Chirps)
+			}
+		})
+	}
+
+	response = adminRequest(t, client, "POST", testServer.URL+"/api/admin/backup?gzip=maybe")
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status code %d | got %d", http.StatusBadRequest, response.StatusCode)
+	}
+}
+
+func TestHandlerBackupThrottled(t *testing.T) {
+	t.Parallel()
+
+	appConfig := newTestConfig(t)
+	appConfig.BackupKeep = 1
+	testServer := newTestServer(t, appConfig)
+	client := testServer.Client()
+
+	response := adminRequest(t, client, "POST", testServer.URL+"/api/admin/backup")
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code %d | got %d", http.StatusCreated, response.StatusCode)
+	}
+	first := backupInfo{}
+	err := json.NewDecoder(response.Body).Decode(&first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// (!) with BackupKeep 1 a second backup would prune the first one
+	response = adminRequest(t, client, "POST", testServer.URL+"/api/admin/backup")
+	response.Body.Close()
+	if response.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("expected status code %d | got %d", http.StatusTooManyRequests, response.StatusCode)
+	}
+	if response.Header.Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+	_, err = os.Stat(first.Path)
+	if err != nil {
+		t.Errorf("expected the first backup to be kept | got %v", err)
+	}
+}
+
+func TestHandlerBackupDefaultConfig(t *testing.T) {
+	t.Parallel()
+
+	// (!) DefaultConfig has no admin token, anyone could otherwise rotate the real backups away
+	appConfig := newTestConfig(t)
+	appConfig.AdminToken = ""
+	testServer := newTestServer(t, appConfig)
+
+	response, err := testServer.Client().Post(testServer.URL+"/api/admin/backup", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("expected status code %d | got %d", http.StatusForbidden, response.StatusCode)
+	}
+	_, err = os.Stat(appConfig.BackupDir)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected no backup directory | got %v", err)
+	}
+}
+
+func TestBackupMinInterval(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	appConfig := DefaultConfig()
+	appConfig.BackupDir = t.TempDir()
+	appConfig.BackupMinInterval = Duration{time.Minute}
+	bm := newBackupManager(db, appConfig)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bm.now = func() time.Time { return now }
+
+	_, err = bm.create(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(20 * time.Second)
+	_, err = bm.create(false)
+	tooSoon := backupTooSoonError{}
+	if !errors.As(err, &tooSoon) || tooSoon.retryAfter != 40*time.Second {
+		t.Errorf("expected to retry after %s | got %v", 40*time.Second, err)
+	}
+	now = now.Add(40 * time.Second)
+	_, err = bm.create(false)
+	if err != nil {
+		t.Errorf("expected a backup once the interval passed | got %v", err)
+	}
+}
+
+func TestBackupRetention(t *testing.T) {
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	appConfig := DefaultConfig()
+	appConfig.BackupDir = t.TempDir()
+	appConfig.BackupKeep = 2
+	bm := newBackupManager(db, appConfig)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bm.now = func() time.Time { return now }
+
+	var created []string
+	for i := 0; i < 4; i++ {
+		info, err := bm.create(i%2 == 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, info.Path)
+		now = now.Add(time.Hour)
+	}
+
+	backups, err := bm.backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(backups, created[2:]) {
+		t.Errorf("expected the newest backups %v | got %v", created[2:], backups)
+	}
+
+	// (!) leftovers from a crash and unrelated files are never counted or deleted
+	entries, err := os.ReadDir(appConfig.BackupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 files in the backup directory | got %d", len(entries))
+	}
+}
+
+func TestReadSnapshotInvalid(t *testing.T) {
+	dir := t.TempDir()
+
+	var gzipped bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gzipped)
+	gzipWriter.Write([]byte(`{"chirps":{"1":{"id":1,"body":"fine"}}}`))
+	gzipWriter.Close()
+
+	testCases := []struct {
+		name    string
+		content []byte
+		valid   bool
+	}{
+		{name: "plain", content: []byte(`{"chirps":{"1":{"id":1,"body":"fine"}}}`), valid: true},
+		{name: "gzip", content: gzipped.Bytes(), valid: true},
+		{name: "empty object", content: []byte(`{}`), valid: true},
+		{name: "not json", content: []byte(`chirps`), valid: false},
+		{name: "truncated", content: []byte(`{"chirps":{"1":{"id":1,`), valid: false},
+		{name: "checksum mismatch", content: []byte(`{"schema_version":2,"checksum":"sha256:00","chirps":{}}`), valid: false},
+		{name: "id mismatch", content: []byte(`{"chirps":{"1":{"id":2,"body":"fine"}}}`), valid: false},
+		{name: "too long", content: []byte(`{"chirps":{"1":{"id":1,"body":"` + strings.Repeat("a", chirpMaxLength+1) + `"}}}`), valid: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, tc.name)
+			err := os.WriteFile(path, tc.content, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = readSnapshot(path, nil)
+			if (err == nil) != tc.valid {
+				t.Errorf("expected valid %t | got %v", tc.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,362 @@
+// Package chirpyclient is a typed client for the Chirpy HTTP API
+//
+//	client, err := chirpyclient.New("http://localhost:8080", chirpyclient.WithAdminToken(token))
+//	chirp, err := client.CreateChirp(ctx, "hello world")
+package chirpyclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Chirp is a chirp as stored by the server
+type Chirp struct {
+	ID   int    `json:"id"`
+	Body string `json:"body"`
+	// DeletedAt is only set on chirps in the trash
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Validation is the verdict of ValidateChirp, CleanedBody is only set when profanity was masked
+type Validation struct {
+	Valid       bool   `json:"valid"`
+	CleanedBody string `json:"cleaned_body"`
+}
+
+// Metrics are the counters shown on the admin metrics page
+type Metrics struct {
+	Visits int `json:"visits"`
+	Panics int `json:"panics"`
+}
+
+// Backup is a snapshot the server wrote next to its database
+type Backup struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Gzip      bool      `json:"gzip"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// export formats accepted by Export
+const (
+	ExportNDJSON = "ndjson"
+	ExportCSV    = "csv"
+)
+
+// TokenSource hands out the bearer token sent to the admin endpoints
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+	// Refresh is called once after a 401, the request is sent again if it returns a different token
+	Refresh(ctx context.Context) (string, error)
+}
+
+// StaticToken is a TokenSource that never changes
+type StaticToken string
+
+func (st StaticToken) Token(ctx context.Context) (string, error)   { return string(st), nil }
+func (st StaticToken) Refresh(ctx context.Context) (string, error) { return string(st), nil }
+
+// Client talks to a single Chirpy server, it's safe for concurrent use
+type Client struct {
+	baseURL     *url.URL
+	httpClient  *http.Client
+	tokenSource TokenSource
+	userAgent   string
+
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient replaces http.DefaultClient, e.g. to set a timeout or a custom transport
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithAdminToken authenticates the admin endpoints with a fixed token
+func WithAdminToken(token string) Option {
+	return WithTokenSource(StaticToken(token))
+}
+
+// WithTokenSource authenticates with tokens from source, refreshing them when the server answers 401
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Client) {
+		c.tokenSource = source
+	}
+}
+
+// WithRetries sets how often a failed call is retried and the backoff between attempts,
+// the backoff doubles after every attempt up to maxBackoff
+func WithRetries(maxRetries int, minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithUserAgent sets the User-Agent header of every request
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// New returns a client for the server at baseURL, like "http://localhost:8080"
+func New(baseURL string, options ...Option) (*Client, error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("chirpy: invalid base URL: %w", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("chirpy: invalid base URL %q: the scheme must be http or https", baseURL)
+	}
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+
+	c := &Client{
+		baseURL:    parsed,
+		httpClient: http.DefaultClient,
+		userAgent:  "chirpyclient",
+		maxRetries: 2,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 2 * time.Second,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c, nil
+}
+
+// CreateChirp posts a new chirp
+func (c *Client) CreateChirp(ctx context.Context, body string) (Chirp, error) {
+	chirp := Chirp{}
+	err := c.doJSON(ctx, http.MethodPost, "/api/chirps", Chirp{Body: body}, &chirp)
+	return chirp, err
+}
+
+// ListChirps returns every chirp, oldest first
+func (c *Client) ListChirps(ctx context.Context) ([]Chirp, error) {
+	chirps := []Chirp{}
+	err := c.doJSON(ctx, http.MethodGet, "/api/chirps", nil, &chirps)
+	return chirps, err
+}
+
+// ValidateChirp checks a chirp without saving it
+func (c *Client) ValidateChirp(ctx context.Context, body string) (Validation, error) {
+	validation := Validation{}
+	err := c.doJSON(ctx, http.MethodPost, "/api/validate_chirp", Chirp{Body: body}, &validation)
+	return validation, err
+}
+
+// Healthz returns nil when the server is ready
+func (c *Client) Healthz(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodGet, "/api/healthz", nil)
+	return err
+}
+
+// DeleteChirp moves a chirp to the trash, it needs the admin token
+func (c *Client) DeleteChirp(ctx context.Context, id int) error {
+	_, err := c.do(ctx, http.MethodDelete, "/api/chirps/"+strconv.Itoa(id), nil)
+	return err
+}
+
+// RestoreChirp takes a chirp out of the trash, it needs the admin token
+// a chirp past the server's trash retention is refused with ErrGone
+func (c *Client) RestoreChirp(ctx context.Context, id int) (Chirp, error) {
+	chirp := Chirp{}
+	err := c.doJSON(ctx, http.MethodPost, "/api/chirps/"+strconv.Itoa(id)+"/restore", nil, &chirp)
+	return chirp, err
+}
+
+// Metrics reads the admin metrics page
+// (!) an older server only has the HTML page, that fails to decode rather than returning zeros
+func (c *Client) Metrics(ctx context.Context) (Metrics, error) {
+	metrics := Metrics{}
+	err := c.doJSON(ctx, http.MethodGet, "/api/admin/metrics", nil, &metrics)
+	return metrics, err
+}
+
+// Export downloads every chirp in format, ExportNDJSON or ExportCSV, it needs the admin token
+func (c *Client) Export(ctx context.Context, format string) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, "/api/admin/export?format="+url.QueryEscape(format), nil)
+}
+
+// Backup has the server snapshot its database, it needs the admin token
+// a backup soon after the last one is refused with ErrTooManyRequests once the retries run out
+func (c *Client) Backup(ctx context.Context, gzip bool) (Backup, error) {
+	backup := Backup{}
+	err := c.doJSON(ctx, http.MethodPost, "/api/admin/backup?gzip="+strconv.FormatBool(gzip), nil, &backup)
+	return backup, err
+}
+
+// ResetMetrics sets the visit counter back to zero
+func (c *Client) ResetMetrics(ctx context.Context) error {
+	_, err := c.do(ctx, http.MethodGet, "/api/admin/reset", nil)
+	return err
+}
+
+// doJSON is do for endpoints that answer with JSON, the response is decoded into out
+func (c *Client) doJSON(ctx context.Context, method, path string, in, out any) error {
+	byteData, err := c.do(ctx, method, path, in)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(byteData, out)
+	if err != nil {
+		return fmt.Errorf("chirpy: decoding %s %s response: %w", method, path, err)
+	}
+	return nil
+}
+
+// do sends the request, retrying when that's safe, and returns the response body
+// a non 2xx response is returned as an *APIError
+func (c *Client) do(ctx context.Context, method, path string, in any) ([]byte, error) {
+	var requestBody []byte
+	if in != nil {
+		var err error
+		requestBody, err = json.Marshal(in)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refreshed := false
+	for attempt := 0; ; attempt++ {
+		response, responseBody, err := c.send(ctx, method, path, requestBody)
+		if err != nil {
+			// (!) a request that never got an answer may still have been handled, only repeat it when that's harmless
+			if ctx.Err() == nil && idempotent(method) && attempt < c.maxRetries {
+				err = c.sleep(ctx, c.backoff(attempt))
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			return nil, err
+		}
+
+		if response.StatusCode >= 200 && response.StatusCode < 300 {
+			return responseBody, nil
+		}
+
+		apiErr := decodeAPIError(response, responseBody)
+		if response.StatusCode == http.StatusUnauthorized && c.tokenSource != nil && !refreshed {
+			refreshed = true
+			changed, err := c.refreshToken(ctx, response.Request)
+			if err != nil {
+				return nil, err
+			}
+			if changed {
+				continue
+			}
+		}
+		if retryable(method, response.StatusCode) && attempt < c.maxRetries {
+			err = c.sleep(ctx, max(c.backoff(attempt), apiErr.RetryAfter))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return nil, apiErr
+	}
+}
+
+// send makes a single attempt
+func (c *Client) send(ctx context.Context, method, path string, requestBody []byte) (*http.Response, []byte, error) {
+	var body io.Reader
+	if requestBody != nil {
+		body = bytes.NewReader(requestBody)
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL.String()+path, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if requestBody != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set("User-Agent", c.userAgent)
+	if c.tokenSource != nil {
+		token, err := c.tokenSource.Token(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("chirpy: getting token: %w", err)
+		}
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return response, responseBody, nil
+}
+
+// refreshToken asks the token source for a new token and reports whether it differs from the one that was refused
+func (c *Client) refreshToken(ctx context.Context, refused *http.Request) (bool, error) {
+	token, err := c.tokenSource.Refresh(ctx)
+	if err != nil {
+		return false, fmt.Errorf("chirpy: refreshing token: %w", err)
+	}
+	return "Bearer "+token != refused.Header.Get("Authorization"), nil
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	backoff := c.minBackoff << attempt
+	if backoff > c.maxBackoff || backoff <= 0 {
+		return c.maxBackoff
+	}
+	return backoff
+}
+
+func (c *Client) sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// idempotent methods can be sent twice without changing the result
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether a response with status is worth another attempt
+func retryable(method string, status int) bool {
+	switch status {
+	case http.StatusTooManyRequests:
+		// (!) the rate limiter refuses requests before any handler runs, so even a POST is safe to repeat
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent(method)
+	}
+	return false
+}
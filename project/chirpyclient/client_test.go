@@ -0,0 +1,166 @@
+package chirpyclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, handler http.Handler, options ...Option) *Client {
+	t.Helper()
+	testServer := httptest.NewServer(handler)
+	t.Cleanup(testServer.Close)
+
+	options = append([]Option{WithRetries(2, time.Millisecond, 5*time.Millisecond)}, options...)
+	client, err := New(testServer.URL, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestRetries(t *testing.T) {
+	testCases := []struct {
+		name             string
+		method           string
+		status           int
+		expectedAttempts int32
+	}{
+		{name: "GET retried on 503", method: "GET", status: http.StatusServiceUnavailable, expectedAttempts: 3},
+		{name: "POST not retried on 503", method: "POST", status: http.StatusServiceUnavailable, expectedAttempts: 1},
+		{name: "POST retried on 429", method: "POST", status: http.StatusTooManyRequests, expectedAttempts: 3},
+		{name: "GET not retried on 400", method: "GET", status: http.StatusBadRequest, expectedAttempts: 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.status)
+				w.Write([]byte(`{"error":"nope"}`))
+			}))
+
+			_, err := client.do(context.Background(), tc.method, "/api/chirps", nil)
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("expected an *APIError | got %v", err)
+			}
+			if apiErr.StatusCode != tc.status || apiErr.Message != "nope" {
+				t.Errorf("expected %d nope | got %d %s", tc.status, apiErr.StatusCode, apiErr.Message)
+			}
+			if attempts.Load() != tc.expectedAttempts {
+				t.Errorf("expected %d attempts | got %d", tc.expectedAttempts, attempts.Load())
+			}
+		})
+	}
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	var attempts atomic.Int32
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`[{"id":1,"body":"hello"}]`))
+	}))
+
+	chirps, err := client.ListChirps(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 1 || chirps[0].Body != "hello" {
+		t.Errorf("expected one chirp | got %v", chirps)
+	}
+}
+
+type rotatingToken struct {
+	token     atomic.Value
+	refreshes atomic.Int32
+}
+
+func (rt *rotatingToken) Token(ctx context.Context) (string, error) {
+	return rt.token.Load().(string), nil
+}
+
+func (rt *rotatingToken) Refresh(ctx context.Context) (string, error) {
+	rt.refreshes.Add(1)
+	rt.token.Store("new")
+	return "new", nil
+}
+
+func TestTokenRefresh(t *testing.T) {
+	tokens := &rotatingToken{}
+	tokens.token.Store("expired")
+
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("Hits reset to 0"))
+	}), WithTokenSource(tokens))
+
+	err := client.ResetMetrics(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens.refreshes.Load() != 1 {
+		t.Errorf("expected 1 refresh | got %d", tokens.refreshes.Load())
+	}
+}
+
+func TestStaticTokenUnauthorized(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}), WithAdminToken("wrong"))
+
+	err := client.ResetMetrics(context.Background())
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected %v | got %v", ErrUnauthorized, err)
+	}
+}
+
+func TestMetricsNotJSON(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<p>Chirpy has been visited 3 times!</p>`))
+	}))
+
+	_, err := client.Metrics(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "decoding GET /api/admin/metrics response") {
+		t.Errorf("expected a decoding error | got %v", err)
+	}
+}
+
+func TestProblemDetails(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.Header().Set("X-Request-ID", "abc-123")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"something broke"}`))
+	}), WithRetries(0, 0, 0))
+
+	_, err := client.CreateChirp(context.Background(), "hello")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an *APIError | got %v", err)
+	}
+	if !errors.Is(err, ErrInternal) || apiErr.Message != "something broke" || apiErr.RequestID != "abc-123" {
+		t.Errorf("expected a 500 with detail and request id | got %+v", apiErr)
+	}
+}
+
+func TestNewInvalidBaseURL(t *testing.T) {
+	_, err := New("localhost:8080")
+	if err == nil {
+		t.Error("expected an error for a base URL without a scheme")
+	}
+}
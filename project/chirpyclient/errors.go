@@ -0,0 +1,80 @@
+package chirpyclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// APIError is a non 2xx response from the server
+type APIError struct {
+	StatusCode int
+	// Message comes from the `{"error": ...}` body, or the detail of a problem+json body
+	Message string
+	// RequestID is the X-Request-ID the server logged the request under
+	RequestID string
+	// RetryAfter is set when the server sent a Retry-After header, e.g. on a 429
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("chirpy: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("chirpy: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is matches on the status code so callers can write errors.Is(err, chirpyclient.ErrUnauthorized)
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.StatusCode == e.StatusCode
+}
+
+var (
+	ErrBadRequest      = &APIError{StatusCode: http.StatusBadRequest}
+	ErrUnauthorized    = &APIError{StatusCode: http.StatusUnauthorized}
+	ErrForbidden       = &APIError{StatusCode: http.StatusForbidden}
+	ErrNotFound        = &APIError{StatusCode: http.StatusNotFound}
+	ErrGone            = &APIError{StatusCode: http.StatusGone}
+	ErrTooLarge        = &APIError{StatusCode: http.StatusRequestEntityTooLarge}
+	ErrTooManyRequests = &APIError{StatusCode: http.StatusTooManyRequests}
+	ErrInternal        = &APIError{StatusCode: http.StatusInternalServerError}
+)
+
+// decodeAPIError builds an APIError from a response the server refused
+// the body is either `{"error": "..."}` or an RFC 9457 problem+json, anything else is left out
+func decodeAPIError(response *http.Response, body []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: response.StatusCode,
+		RequestID:  response.Header.Get("X-Request-ID"),
+		RetryAfter: retryAfter(response),
+	}
+
+	var errorBody struct {
+		Error  string `json:"error"`
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	}
+	if json.Unmarshal(body, &errorBody) == nil {
+		switch {
+		case errorBody.Error != "":
+			apiErr.Message = errorBody.Error
+		case errorBody.Detail != "":
+			apiErr.Message = errorBody.Detail
+		case errorBody.Title != "":
+			apiErr.Message = errorBody.Title
+		}
+	}
+	return apiErr
+}
+
+// retryAfter reads a Retry-After header in seconds, the server never sends the HTTP date form
+func retryAfter(response *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(response.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
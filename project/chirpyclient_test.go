@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-web-servers/chirpyclient"
+)
+
+// TestChirpyClient runs the SDK against the real server
+func TestChirpyClient(t *testing.T) {
+	t.Parallel()
+
+	appConfig := newTestConfig(t)
+	testServer := newTestServer(t, appConfig)
+
+	ctx := context.Background()
+	client, err := chirpyclient.New(testServer.URL, chirpyclient.WithHTTPClient(testServer.Client()), chirpyclient.WithAdminToken(testAdminToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.Healthz(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, body := range []string{"first", "second"} {
+		_, err := client.CreateChirp(ctx, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	chirps, err := client.ListChirps(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedChirps := []chirpyclient.Chirp{{ID: 1, Body: "first"}, {ID: 2, Body: "second"}}
+	if !reflect.DeepEqual(chirps, expectedChirps) {
+		t.Errorf("expected %v | got %v", expectedChirps, chirps)
+	}
+
+	_, err = client.CreateChirp(ctx, strings.Repeat("a", 141))
+	var apiErr *chirpyclient.APIError
+	if !errors.As(err, &apiErr) || !errors.Is(err, chirpyclient.ErrBadRequest) || apiErr.Message != "Chirp is too long" {
+		t.Errorf("expected a 400 Chirp is too long | got %v", err)
+	}
+
+	validation, err := client.ValidateChirp(ctx, "what a kerfuffle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if validation.CleanedBody != "what a ****" {
+		t.Errorf("expected %s | got %s", "what a ****", validation.CleanedBody)
+	}
+
+	err = client.ResetMetrics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := client.Metrics(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics != (chirpyclient.Metrics{}) {
+		t.Errorf("expected zeroed metrics | got %+v", metrics)
+	}
+
+	err = client.DeleteChirp(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.DeleteChirp(ctx, 1)
+	if !errors.Is(err, chirpyclient.ErrNotFound) {
+		t.Errorf("expected %v deleting twice | got %v", chirpyclient.ErrNotFound, err)
+	}
+	restored, err := client.RestoreChirp(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored, expectedChirps[0]) {
+		t.Errorf("expected %v | got %v", expectedChirps[0], restored)
+	}
+
+	export, err := client.Export(ctx, chirpyclient.ExportCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedExport := "collection,id,body\nchirps,1,first\nchirps,2,second\n"
+	if string(export) != expectedExport {
+		t.Errorf("expected %q | got %q", expectedExport, export)
+	}
+	_, err = client.Export(ctx, "xml")
+	if !errors.Is(err, chirpyclient.ErrBadRequest) {
+		t.Errorf("expected %v for an unknown format | got %v", chirpyclient.ErrBadRequest, err)
+	}
+
+	backup, err := client.Backup(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !backup.Gzip || backup.Size == 0 || filepath.Dir(backup.Path) != appConfig.BackupDir {
+		t.Errorf("expected a gzipped backup in %s | got %+v", appConfig.BackupDir, backup)
+	}
+
+	anonymous, err := chirpyclient.New(testServer.URL, chirpyclient.WithHTTPClient(testServer.Client()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = anonymous.Metrics(ctx)
+	if !errors.Is(err, chirpyclient.ErrUnauthorized) {
+		t.Errorf("expected %v | got %v", chirpyclient.ErrUnauthorized, err)
+	}
+	err = anonymous.DeleteChirp(ctx, 1)
+	if !errors.Is(err, chirpyclient.ErrUnauthorized) {
+		t.Errorf("expected %v | got %v", chirpyclient.ErrUnauthorized, err)
+	}
+}
@@ -0,0 +1,479 @@
+// Command chirpctl talks to a Chirpy server from the command line
+//
+//	chirpctl [-server URL] [-output table|json] <command> [arguments]
+//
+// the server URL and admin token saved by `chirpctl login` are cached in $XDG_CONFIG_HOME/chirpctl/config.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go-web-servers/chirpyclient"
+)
+
+const (
+	exitCodeOK    = 0
+	exitCodeError = 1
+	exitCodeUsage = 2
+)
+
+const defaultServer = "http://localhost:8080"
+
+const usage = `usage: chirpctl [flags] <command> [arguments]
+
+commands:
+  post <body>      post a chirp, "-" reads the body from stdin
+  list             list every chirp
+  get <id>         show a single chirp
+  delete <id>      move a chirp to the trash (needs a token)
+  restore <id>     take a chirp out of the trash (needs a token)
+  validate <body>  check a chirp without posting it
+  login            verify an admin token and cache it with the server URL
+  logout           forget the cached token
+  metrics          show the admin metrics (needs a token)
+  reset            reset the visit counter (needs a token)
+  export [format]  download every chirp as ndjson (default) or csv (needs a token)
+  backup [-gzip]   have the server back up its database (needs a token)
+  health           check the server is ready
+
+flags:
+`
+
+// cliConfig is what login caches between runs
+type cliConfig struct {
+	Server string `json:"server,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.LookupEnv, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run is main without the process, it returns the exit code
+func run(ctx context.Context, args []string, lookupEnv func(string) (string, bool), stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("chirpctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", defaultConfigPath(), "file the server URL and token are cached in")
+	server := fs.String("server", "", "Chirpy server URL (default: the cached one, $CHIRPCTL_SERVER or "+defaultServer+")")
+	token := fs.String("token", "", "admin token (default: the cached one or $CHIRPCTL_TOKEN)")
+	output := fs.String("output", "table", "output format: table or json")
+
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitCodeOK
+	}
+	if err != nil {
+		return exitCodeUsage
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "chirpctl: -output must be table or json, got %q\n", *output)
+		return exitCodeUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitCodeUsage
+	}
+
+	cached, err := loadCLIConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(stderr, "chirpctl:", err)
+		return exitCodeError
+	}
+	getenv := func(key string) string {
+		value, _ := lookupEnv(key)
+		return value
+	}
+	// (!) flags beat environment variables, which beat the cached config
+	resolved := cliConfig{
+		Server: firstNonEmpty(*server, getenv("CHIRPCTL_SERVER"), cached.Server, defaultServer),
+		Token:  firstNonEmpty(*token, getenv("CHIRPCTL_TOKEN")),
+	}
+	// (!) the cached token was verified against the cached server, it must never be sent to another one
+	if resolved.Token == "" && resolved.Server == cached.Server {
+		resolved.Token = cached.Token
+	}
+
+	client, err := chirpyclient.New(resolved.Server, chirpyclient.WithAdminToken(resolved.Token), chirpyclient.WithUserAgent("chirpctl"))
+	if err != nil {
+		fmt.Fprintln(stderr, "chirpctl:", err)
+		return exitCodeUsage
+	}
+
+	cmd := &command{
+		ctx:        ctx,
+		client:     client,
+		args:       fs.Args()[1:],
+		stdin:      stdin,
+		stdout:     stdout,
+		json:       *output == "json",
+		configPath: *configPath,
+		config:     resolved,
+	}
+
+	var handler func() error
+	switch name := fs.Arg(0); name {
+	case "post":
+		handler = cmd.post
+	case "list":
+		handler = cmd.list
+	case "get":
+		handler = cmd.get
+	case "delete":
+		handler = cmd.delete
+	case "restore":
+		handler = cmd.restore
+	case "validate":
+		handler = cmd.validate
+	case "login":
+		handler = cmd.login
+	case "logout":
+		handler = cmd.logout
+	case "metrics":
+		handler = cmd.metrics
+	case "reset":
+		handler = cmd.reset
+	case "health":
+		handler = cmd.health
+	case "export":
+		handler = cmd.export
+	case "backup":
+		handler = cmd.backup
+	default:
+		fmt.Fprintf(stderr, "chirpctl: unknown command %q\n", name)
+		fs.Usage()
+		return exitCodeUsage
+	}
+
+	err = handler()
+	var usageErr usageError
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(stderr, "chirpctl:", err)
+		return exitCodeUsage
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, "chirpctl:", err)
+		return exitCodeError
+	}
+	return exitCodeOK
+}
+
+// usageError is a command called with the wrong arguments
+type usageError string
+
+func (ue usageError) Error() string {
+	return string(ue)
+}
+
+// command is everything a subcommand needs
+type command struct {
+	ctx        context.Context
+	client     *chirpyclient.Client
+	args       []string
+	stdin      io.Reader
+	stdout     io.Writer
+	json       bool
+	configPath string
+	config     cliConfig
+}
+
+func (cmd *command) post() error {
+	body, err := cmd.body("post <body>")
+	if err != nil {
+		return err
+	}
+	chirp, err := cmd.client.CreateChirp(cmd.ctx, body)
+	if err != nil {
+		return err
+	}
+	return cmd.printChirps([]chirpyclient.Chirp{chirp}, chirp)
+}
+
+func (cmd *command) list() error {
+	if len(cmd.args) != 0 {
+		return usageError("usage: list")
+	}
+	chirps, err := cmd.client.ListChirps(cmd.ctx)
+	if err != nil {
+		return err
+	}
+	return cmd.printChirps(chirps, chirps)
+}
+
+func (cmd *command) get() error {
+	id, err := cmd.chirpID("get <id>")
+	if err != nil {
+		return err
+	}
+
+	// (!) the API has no endpoint for a single chirp yet, so we look for it in the list
+	chirps, err := cmd.client.ListChirps(cmd.ctx)
+	if err != nil {
+		return err
+	}
+	for _, chirp := range chirps {
+		if chirp.ID == id {
+			return cmd.printChirps([]chirpyclient.Chirp{chirp}, chirp)
+		}
+	}
+	return fmt.Errorf("chirp %d not found", id)
+}
+
+func (cmd *command) delete() error {
+	id, err := cmd.chirpID("delete <id>")
+	if err != nil {
+		return err
+	}
+	err = cmd.client.DeleteChirp(cmd.ctx, id)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.stdout, "chirp %d moved to the trash, `chirpctl restore %d` brings it back\n", id, id)
+	return nil
+}
+
+func (cmd *command) restore() error {
+	id, err := cmd.chirpID("restore <id>")
+	if err != nil {
+		return err
+	}
+	chirp, err := cmd.client.RestoreChirp(cmd.ctx, id)
+	if err != nil {
+		return err
+	}
+	return cmd.printChirps([]chirpyclient.Chirp{chirp}, chirp)
+}
+
+func (cmd *command) validate() error {
+	body, err := cmd.body("validate <body>")
+	if err != nil {
+		return err
+	}
+	validation, err := cmd.client.ValidateChirp(cmd.ctx, body)
+	if err != nil {
+		return err
+	}
+	if cmd.json {
+		return cmd.printJSON(validation)
+	}
+	if validation.CleanedBody != "" {
+		fmt.Fprintf(cmd.stdout, "valid once cleaned: %s\n", validation.CleanedBody)
+		return nil
+	}
+	fmt.Fprintln(cmd.stdout, "valid")
+	return nil
+}
+
+// login checks the token against an admin endpoint before caching it, so a typo fails straight away
+func (cmd *command) login() error {
+	if len(cmd.args) != 0 {
+		return usageError("usage: login (pass the token with -token or $CHIRPCTL_TOKEN)")
+	}
+	if cmd.config.Token == "" {
+		return usageError("login needs a token, pass it with -token or $CHIRPCTL_TOKEN")
+	}
+	_, err := cmd.client.Metrics(cmd.ctx)
+	if err != nil {
+		return err
+	}
+	err = saveCLIConfig(cmd.configPath, cmd.config)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(cmd.stdout, "logged in to %s\n", cmd.config.Server)
+	return nil
+}
+
+func (cmd *command) logout() error {
+	cached, err := loadCLIConfig(cmd.configPath)
+	if err != nil {
+		return err
+	}
+	cached.Token = ""
+	return saveCLIConfig(cmd.configPath, cached)
+}
+
+func (cmd *command) metrics() error {
+	metrics, err := cmd.client.Metrics(cmd.ctx)
+	if err != nil {
+		return err
+	}
+	if cmd.json {
+		return cmd.printJSON(metrics)
+	}
+	tw := tabwriter.NewWriter(cmd.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VISITS\tPANICS")
+	fmt.Fprintf(tw, "%d\t%d\n", metrics.Visits, metrics.Panics)
+	return tw.Flush()
+}
+
+func (cmd *command) reset() error {
+	err := cmd.client.ResetMetrics(cmd.ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.stdout, "visits reset to 0")
+	return nil
+}
+
+func (cmd *command) export() error {
+	if len(cmd.args) > 1 {
+		return usageError("usage: export [ndjson|csv]")
+	}
+	format := chirpyclient.ExportNDJSON
+	if len(cmd.args) == 1 {
+		format = cmd.args[0]
+	}
+	byteData, err := cmd.client.Export(cmd.ctx, format)
+	if err != nil {
+		return err
+	}
+	// the export is already in the format that was asked for, -output doesn't apply
+	_, err = cmd.stdout.Write(byteData)
+	return err
+}
+
+func (cmd *command) backup() error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	gzip := fs.Bool("gzip", false, "gzip the backup")
+	err := fs.Parse(cmd.args)
+	if err != nil || fs.NArg() != 0 {
+		return usageError("usage: backup [-gzip]")
+	}
+	backup, err := cmd.client.Backup(cmd.ctx, *gzip)
+	if err != nil {
+		return err
+	}
+	if cmd.json {
+		return cmd.printJSON(backup)
+	}
+	tw := tabwriter.NewWriter(cmd.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tSIZE\tGZIP\tCREATED")
+	fmt.Fprintf(tw, "%s\t%d\t%t\t%s\n", backup.Path, backup.Size, backup.Gzip, backup.CreatedAt.Format(time.RFC3339))
+	return tw.Flush()
+}
+
+func (cmd *command) health() error {
+	err := cmd.client.Healthz(cmd.ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(cmd.stdout, "ok")
+	return nil
+}
+
+// chirpID is the single argument of a command that takes a chirp id
+func (cmd *command) chirpID(usage string) (int, error) {
+	if len(cmd.args) != 1 {
+		return 0, usageError("usage: " + usage)
+	}
+	id, err := strconv.Atoi(cmd.args[0])
+	if err != nil {
+		return 0, usageError(fmt.Sprintf("invalid chirp id %q", cmd.args[0]))
+	}
+	return id, nil
+}
+
+// body joins the arguments into a chirp, "-" reads it from stdin instead
+func (cmd *command) body(usage string) (string, error) {
+	if len(cmd.args) == 0 {
+		return "", usageError("usage: " + usage)
+	}
+	if len(cmd.args) == 1 && cmd.args[0] == "-" {
+		byteData, err := io.ReadAll(cmd.stdin)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(byteData), "\n"), nil
+	}
+	return strings.Join(cmd.args, " "), nil
+}
+
+// printChirps prints chirps as a table, or value as JSON
+func (cmd *command) printChirps(chirps []chirpyclient.Chirp, value any) error {
+	if cmd.json {
+		return cmd.printJSON(value)
+	}
+	tw := tabwriter.NewWriter(cmd.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tBODY")
+	for _, chirp := range chirps {
+		fmt.Fprintf(tw, "%d\t%s\n", chirp.ID, chirp.Body)
+	}
+	return tw.Flush()
+}
+
+func (cmd *command) printJSON(value any) error {
+	encoder := json.NewEncoder(cmd.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".chirpctl.json"
+	}
+	return filepath.Join(dir, "chirpctl", "config.json")
+}
+
+// loadCLIConfig returns an empty config when the file doesn't exist yet
+func loadCLIConfig(path string) (cliConfig, error) {
+	cached := cliConfig{}
+	byteData, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cached, nil
+	}
+	if err != nil {
+		return cached, err
+	}
+	err = json.Unmarshal(byteData, &cached)
+	if err != nil {
+		return cached, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cached, nil
+}
+
+func saveCLIConfig(path string, cached cliConfig) error {
+	byteData, err := json.MarshalIndent(cached, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	// (!) the file holds a credential, only its owner may read it
+	err = os.WriteFile(path, append(byteData, '\n'), 0600)
+	if err != nil {
+		return err
+	}
+	// WriteFile keeps the mode of a file that already exists
+	return os.Chmod(path, 0600)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
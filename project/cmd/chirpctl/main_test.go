@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeChirpy mimics the endpoints of the Chirpy server chirpctl uses
+func fakeChirpy(t *testing.T, adminToken string) *httptest.Server {
+	t.Helper()
+
+	var mux sync.Mutex
+	chirps := []map[string]any{}
+	trash := map[string]bool{}
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("POST /api/chirps", func(w http.ResponseWriter, r *http.Request) {
+		var chirp map[string]any
+		json.NewDecoder(r.Body).Decode(&chirp)
+		mux.Lock()
+		chirp["id"] = len(chirps) + 1
+		chirps = append(chirps, chirp)
+		mux.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(chirp)
+	})
+	handler.HandleFunc("GET /api/chirps", func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		defer mux.Unlock()
+		json.NewEncoder(w).Encode(chirps)
+	})
+	// the trash only tracks ids, chirps are never hidden from the list
+	handler.HandleFunc("DELETE /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+adminToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		if trash[r.PathValue("chirpID")] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		trash[r.PathValue("chirpID")] = true
+		w.WriteHeader(http.StatusNoContent)
+	})
+	handler.HandleFunc("POST /api/chirps/{chirpID}/restore", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+adminToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		if !trash[r.PathValue("chirpID")] {
+			w.WriteHeader(http.StatusGone)
+			w.Write([]byte(`{"error":"Chirp was deleted more than 720h0m0s ago"}`))
+			return
+		}
+		delete(trash, r.PathValue("chirpID"))
+		for _, chirp := range chirps {
+			if fmt.Sprint(chirp["id"]) == r.PathValue("chirpID") {
+				json.NewEncoder(w).Encode(chirp)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	handler.HandleFunc("GET /api/admin/export", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+adminToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mux.Lock()
+		defer mux.Unlock()
+		for _, chirp := range chirps {
+			fmt.Fprintf(w, "%s,%v,%v\n", r.URL.Query().Get("format"), chirp["id"], chirp["body"])
+		}
+	})
+	handler.HandleFunc("POST /api/admin/backup", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+adminToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"path":"backups/database.json","size":42,"gzip":%s,"created_at":"2026-10-19T12:00:00Z"}`, r.URL.Query().Get("gzip"))
+	})
+	handler.HandleFunc("GET /api/admin/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+adminToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"visits":7,"panics":0}`))
+	})
+
+	testServer := httptest.NewServer(handler)
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+// runChirpctl runs chirpctl with an empty environment, so CHIRPCTL_* of whoever runs the tests don't leak in
+func runChirpctl(t *testing.T, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	return runChirpctlEnv(t, nil, stdin, args...)
+}
+
+func runChirpctlEnv(t *testing.T, env map[string]string, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, lookupEnv, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestChirps(t *testing.T) {
+	testServer := fakeChirpy(t, "s3cret")
+	config := filepath.Join(t.TempDir(), "config.json")
+	flags := []string{"-config", config, "-server", testServer.URL}
+
+	code, stdout, stderr := runChirpctl(t, "", append(flags, "post", "hello", "world")...)
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr)
+	}
+	if !strings.Contains(stdout, "1   hello world") {
+		t.Errorf("expected a table with the new chirp | got %s", stdout)
+	}
+
+	code, _, stderr = runChirpctl(t, "from stdin\n", append(flags, "post", "-")...)
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr)
+	}
+
+	code, stdout, _ = runChirpctl(t, "", append(flags, "-output", "json", "list")...)
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d", exitCodeOK, code)
+	}
+	var chirps []struct {
+		ID   int    `json:"id"`
+		Body string `json:"body"`
+	}
+	err := json.Unmarshal([]byte(stdout), &chirps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 2 || chirps[1].Body != "from stdin" {
+		t.Errorf("expected 2 chirps, the last from stdin | got %v", chirps)
+	}
+
+	code, stdout, _ = runChirpctl(t, "", append(flags, "get", "2")...)
+	if code != exitCodeOK || !strings.Contains(stdout, "from stdin") {
+		t.Errorf("expected chirp 2 | got %d %s", code, stdout)
+	}
+
+	code, _, stderr = runChirpctl(t, "", append(flags, "get", "42")...)
+	if code != exitCodeError || !strings.Contains(stderr, "chirp 42 not found") {
+		t.Errorf("expected chirp 42 not found | got %d %s", code, stderr)
+	}
+}
+
+func TestTrash(t *testing.T) {
+	testServer := fakeChirpy(t, "s3cret")
+	config := filepath.Join(t.TempDir(), "config.json")
+	flags := []string{"-config", config, "-server", testServer.URL, "-token", "s3cret"}
+
+	code, _, stderr := runChirpctl(t, "", append(flags, "post", "oops")...)
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr)
+	}
+
+	code, _, stderr = runChirpctl(t, "", "-config", config, "-server", testServer.URL, "delete", "1")
+	if code != exitCodeError || !strings.Contains(stderr, "401") {
+		t.Errorf("expected a 401 without a token | got %d %s", code, stderr)
+	}
+
+	code, stdout, stderr := runChirpctl(t, "", append(flags, "delete", "1")...)
+	if code != exitCodeOK || !strings.Contains(stdout, "chirp 1 moved to the trash") {
+		t.Errorf("expected chirp 1 deleted | got %d %s %s", code, stdout, stderr)
+	}
+	code, _, stderr = runChirpctl(t, "", append(flags, "delete", "1")...)
+	if code != exitCodeError || !strings.Contains(stderr, "404") {
+		t.Errorf("expected a 404 deleting twice | got %d %s", code, stderr)
+	}
+
+	code, stdout, stderr = runChirpctl(t, "", append(flags, "restore", "1")...)
+	if code != exitCodeOK || !strings.Contains(stdout, "1   oops") {
+		t.Errorf("expected chirp 1 restored | got %d %s %s", code, stdout, stderr)
+	}
+	code, _, stderr = runChirpctl(t, "", append(flags, "restore", "1")...)
+	if code != exitCodeError || !strings.Contains(stderr, "Chirp was deleted more than") {
+		t.Errorf("expected the server's error | got %d %s", code, stderr)
+	}
+}
+
+func TestExportBackup(t *testing.T) {
+	testServer := fakeChirpy(t, "s3cret")
+	flags := []string{"-config", filepath.Join(t.TempDir(), "config.json"), "-server", testServer.URL, "-token", "s3cret"}
+
+	code, _, stderr := runChirpctl(t, "", append(flags, "post", "exported")...)
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr)
+	}
+
+	code, stdout, stderr := runChirpctl(t, "", append(flags, "export")...)
+	if code != exitCodeOK || stdout != "ndjson,1,exported\n" {
+		t.Errorf("expected the ndjson export | got %d %q %s", code, stdout, stderr)
+	}
+	code, stdout, _ = runChirpctl(t, "", append(flags, "export", "csv")...)
+	if code != exitCodeOK || stdout != "csv,1,exported\n" {
+		t.Errorf("expected the csv export | got %d %q", code, stdout)
+	}
+
+	code, stdout, stderr = runChirpctl(t, "", append(flags, "backup", "-gzip")...)
+	if code != exitCodeOK || !strings.Contains(stdout, "backups/database.json  42    true") {
+		t.Errorf("expected a gzipped backup | got %d %s %s", code, stdout, stderr)
+	}
+	code, stdout, _ = runChirpctl(t, "", append(flags, "-output", "json", "backup")...)
+	if code != exitCodeOK || !strings.Contains(stdout, `"gzip": false`) {
+		t.Errorf("expected a plain backup | got %d %s", code, stdout)
+	}
+}
+
+func TestCachedTokenStaysWithItsServer(t *testing.T) {
+	testServer := fakeChirpy(t, "s3cret")
+	config := filepath.Join(t.TempDir(), "config.json")
+	code, _, stderr := runChirpctl(t, "", "-config", config, "-server", testServer.URL, "-token", "s3cret", "login")
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr)
+	}
+
+	var mux sync.Mutex
+	authorizations := []string{}
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.Lock()
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		mux.Unlock()
+		w.Write([]byte("[]"))
+	}))
+	t.Cleanup(other.Close)
+
+	runChirpctl(t, "", "-config", config, "-server", other.URL, "list")
+	runChirpctlEnv(t, map[string]string{"CHIRPCTL_SERVER": other.URL}, "", "-config", config, "list")
+	mux.Lock()
+	defer mux.Unlock()
+	if len(authorizations) != 2 {
+		t.Fatalf("expected 2 requests | got %d", len(authorizations))
+	}
+	for _, authorization := range authorizations {
+		if authorization != "" {
+			t.Errorf("expected no Authorization header for another server | got %q", authorization)
+		}
+	}
+
+	// an explicit token is sent wherever the user points it
+	code, _, _ = runChirpctlEnv(t, map[string]string{"CHIRPCTL_TOKEN": "s3cret"}, "", "-config", config, "-server", testServer.URL, "metrics")
+	if code != exitCodeOK {
+		t.Errorf("expected the token from CHIRPCTL_TOKEN to be used | got exit code %d", code)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	testServer := fakeChirpy(t, "s3cret")
+	config := filepath.Join(t.TempDir(), "chirpctl", "config.json")
+
+	code, _, stderr := runChirpctl(t, "", "-config", config, "-server", testServer.URL, "-token", "wrong", "login")
+	if code != exitCodeError || !strings.Contains(stderr, "401") {
+		t.Errorf("expected a 401 | got %d %s", code, stderr)
+	}
+	if _, err := os.Stat(config); err == nil {
+		t.Error("expected a refused token not to be cached")
+	}
+
+	code, _, stderr = runChirpctl(t, "", "-config", config, "-server", testServer.URL, "-token", "s3cret", "login")
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr)
+	}
+	info, err := os.Stat(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode %v | got %v", os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// (!) the cached server and token are used without any flags
+	code, stdout, stderr := runChirpctl(t, "", "-config", config, "-output", "json", "metrics")
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr)
+	}
+	if !strings.Contains(stdout, `"visits": 7`) {
+		t.Errorf("expected 7 visits | got %s", stdout)
+	}
+
+	code, _, _ = runChirpctl(t, "", "-config", config, "logout")
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d", exitCodeOK, code)
+	}
+	code, _, _ = runChirpctl(t, "", "-config", config, "metrics")
+	if code != exitCodeError {
+		t.Errorf("expected exit code %d after logout | got %d", exitCodeError, code)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "no command", args: []string{}},
+		{name: "unknown command", args: []string{"explode"}},
+		{name: "unknown output", args: []string{"-output", "xml", "list"}},
+		{name: "post without body", args: []string{"post"}},
+		{name: "get without id", args: []string{"get"}},
+		{name: "get with a bad id", args: []string{"get", "one"}},
+		{name: "delete without id", args: []string{"delete"}},
+		{name: "restore with a bad id", args: []string{"restore", "one"}},
+		{name: "export with two formats", args: []string{"export", "csv", "ndjson"}},
+		{name: "backup with an argument", args: []string{"backup", "now"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"-config", filepath.Join(t.TempDir(), "config.json"), "-server", "http://127.0.0.1:1"}, tc.args...)
+			code, _, _ := runChirpctl(t, "", args...)
+			if code != exitCodeUsage {
+				t.Errorf("expected exit code %d | got %d", exitCodeUsage, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// exitCodeError is a maintenance command that ran and failed
+const exitCodeError = 1
+
+// command is a maintenance subcommand of the chirpy binary,
+// it takes its own flags plus -config and -db-path to find the database
+type command struct {
+	summary string
+	run     func(fs *flag.FlagSet, args []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+var commands map[string]command
+
+// (!) filled in by init because the commands reach LoadConfig, whose usage message lists the commands
+func init() {
+	commands = map[string]command{
+		"fsck":    {summary: "check the database for corruption and inconsistencies", run: commandFsck},
+		"export":  {summary: "write every record as NDJSON or CSV", run: commandExport},
+		"import":  {summary: "add the records of an NDJSON or CSV export", run: commandImport},
+		"migrate": {summary: "upgrade the database to the current schema version", run: commandMigrate},
+		"restore": {summary: "replace the database with a backup, safe while the server runs", run: commandRestore},
+	}
+}
+
+func isCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// usageError is a command called with the wrong arguments
+type usageError struct {
+	err error
+}
+
+func (ue usageError) Error() string {
+	return ue.err.Error()
+}
+
+func runCommand(name string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	cmd := commands[name]
+	fs := flag.NewFlagSet("chirpy "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of chirpy %s: %s\n\n", name, cmd.summary)
+		fs.PrintDefaults()
+	}
+
+	err := cmd.run(fs, args, stdin, stdout, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitCodeOK
+	}
+	var ue usageError
+	if errors.As(err, &ue) {
+		fmt.Fprintf(stderr, "chirpy %s: %s\n", name, err)
+		return exitCodeUsage
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "chirpy %s: %s\n", name, err)
+		return exitCodeError
+	}
+	return exitCodeOK
+}
+
+// commandNames lists the commands for usage messages
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseCommandFlags parses args into fs and resolves the server configuration the command works on,
+// -db-path overrides whatever the environment or the -config file say
+func parseCommandFlags(fs *flag.FlagSet, args []string) (Config, error) {
+	configPath := fs.String("config", "", "path to a JSON config file (env "+envName("config")+")")
+	dbPath := fs.String("db-path", "", "database file (default: the one the server uses)")
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return Config{}, err
+	}
+	if err != nil {
+		return Config{}, usageError{err}
+	}
+
+	var configArgs []string
+	if *configPath != "" {
+		configArgs = append(configArgs, "-config", *configPath)
+	}
+	if *dbPath != "" {
+		configArgs = append(configArgs, "-db-path", *dbPath)
+	}
+	appConfig, _, err := LoadConfig(configArgs, os.LookupEnv, io.Discard)
+	if err != nil {
+		return Config{}, err
+	}
+	return appConfig, nil
+}
+
+func commandExport(fs *flag.FlagSet, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	format := fs.String("format", exportFormatNDJSON, "ndjson or csv")
+	output := fs.String("o", "-", "file to write, - for stdout")
+	appConfig, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments %v", fs.Args())}
+	}
+	if _, err := exportContentType(*format); err != nil {
+		return usageError{err}
+	}
+
+	db, err := openDB(appConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	chirps, err := db.GetChirps()
+	if err != nil {
+		return err
+	}
+
+	if *output == "-" {
+		return exportChirps(stdout, *format, chirps)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	err = exportChirps(file, *format, chirps)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// commandImport validates the whole file before writing anything, a single bad line aborts the import
+func commandImport(fs *flag.FlagSet, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	format := fs.String("format", exportFormatNDJSON, "ndjson or csv")
+	dryRun := fs.Bool("dry-run", false, "only validate the file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of chirpy import: chirpy import [flags] <file>, - reads stdin\n\n")
+		fs.PrintDefaults()
+	}
+	appConfig, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{errors.New("expected exactly one file to import, - for stdin")}
+	}
+	if _, err := exportContentType(*format); err != nil {
+		return usageError{err}
+	}
+
+	input := stdin
+	if fs.Arg(0) != "-" {
+		file, err := os.Open(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	chirps, err := parseImport(input, *format)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(stdout, "%d chirps are valid\n", len(chirps))
+		return nil
+	}
+
+	db, err := openDB(appConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	bodies := make([]string, 0, len(chirps))
+	for _, chirp := range chirps {
+		bodies = append(bodies, chirp.Body)
+	}
+	created, err := db.CreateChirps(bodies)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "imported %d chirps\n", len(created))
+	return nil
+}
+
+// commandRestore checks the snapshot completely before it replaces the database
+// it takes the database lock like any write, and the server reads the file on every request, so it doesn't need to be stopped
+func commandRestore(fs *flag.FlagSet, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	dryRun := fs.Bool("dry-run", false, "only validate the snapshot")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of chirpy restore: chirpy restore [flags] <snapshot>\n\n")
+		fs.PrintDefaults()
+	}
+	appConfig, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError{errors.New("expected exactly one snapshot to restore")}
+	}
+
+	keys, err := appConfig.dbKeys()
+	if err != nil {
+		return err
+	}
+	dbStructure, err := readSnapshot(fs.Arg(0), keys)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		fmt.Fprintf(stdout, "%s is valid, %d chirps\n", fs.Arg(0), len(dbStructure.Chirps))
+		return nil
+	}
+
+	db, err := openDB(appConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = db.Restore(dbStructure)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "restored %d chirps from %s\n", len(dbStructure.Chirps), fs.Arg(0))
+	return nil
+}
+
+// commandMigrate runs the migrations NewDB would run when the server starts
+func commandMigrate(fs *flag.FlagSet, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	dryRun := fs.Bool("dry-run", false, "only list the migrations that would run")
+	appConfig, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments %v", fs.Args())}
+	}
+
+	keys, err := appConfig.dbKeys()
+	if err != nil {
+		return err
+	}
+	var applied []string
+	err = withFileLock(appConfig.DBPath, !*dryRun, appConfig.DBLockTimeout.Duration, func() error {
+		applied, err = migrateFile(appConfig.DBPath, keys, *dryRun)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		fmt.Fprintf(stdout, "%s is at schema version %d\n", appConfig.DBPath, currentSchemaVersion)
+		return nil
+	}
+	verb := "migrated"
+	if *dryRun {
+		verb = "would migrate"
+	}
+	for _, migration := range applied {
+		fmt.Fprintf(stdout, "%s %s\n", verb, migration)
+	}
+	return nil
+}
+
+// commandFsck reports every issue it finds, -repair fixes the repairable ones after copying the original aside
+func commandFsck(fs *flag.FlagSet, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	repair := fs.Bool("repair", false, "fix duplicate ids, key/id mismatches and stale checksums, keeping the original as <db-path>.fsck-<time>")
+	appConfig, err := parseCommandFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageError{fmt.Errorf("unexpected arguments %v", fs.Args())}
+	}
+
+	keys, err := appConfig.dbKeys()
+	if err != nil {
+		return err
+	}
+	// (!) the whole check holds the lock, a repair must not overwrite a write that landed after the read
+	return withFileLock(appConfig.DBPath, *repair, appConfig.DBLockTimeout.Duration, func() error {
+		return fsck(appConfig.DBPath, keys, *repair, stdout)
+	})
+}
+
+// fsck checks the database at dbPath, the caller holds its lock
+func fsck(dbPath string, keys *dbKeys, repair bool, stdout io.Writer) error {
+	// (!) read the file directly, NewDB would migrate it and a corrupt file must stay as it is until we repair it
+	byteData, err := os.ReadFile(dbPath)
+	if err != nil {
+		return err
+	}
+	plaintext, err := keys.decrypt(byteData)
+	if err != nil {
+		return err
+	}
+	issues, repaired, err := fsckData(plaintext)
+	if err != nil {
+		return err
+	}
+	if len(issues) == 0 {
+		fmt.Fprintf(stdout, "%s: ok, %d chirps\n", dbPath, len(repaired.Chirps))
+		return nil
+	}
+
+	unrepairable := 0
+	for _, issue := range issues {
+		note := ""
+		if !issue.Repairable {
+			note = " (can't be repaired automatically)"
+			unrepairable++
+		}
+		fmt.Fprintf(stdout, "%s: %s%s\n", dbPath, issue.Description, note)
+	}
+	if !repair {
+		return fmt.Errorf("found %d issues, run with -repair to fix the repairable ones", len(issues))
+	}
+
+	original := dbPath + ".fsck-" + time.Now().UTC().Format(rotatedTimeFormat)
+	err = writeFileAtomic(original, byteData)
+	if err != nil {
+		return err
+	}
+	db := &DB{path: dbPath, mux: &sync.RWMutex{}, keys: keys}
+	err = db.writeDB(repaired)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "repaired %d issues, the original is in %s\n", len(issues)-unrepairable, original)
+	if unrepairable > 0 {
+		return fmt.Errorf("%d issues need fixing by hand", unrepairable)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCommandExportImport(t *testing.T) {
+	t.Setenv("CHIRPY_CONFIG", "")
+	source := filepath.Join(t.TempDir(), "source.json")
+	destination := filepath.Join(t.TempDir(), "destination.json")
+
+	db, err := NewDB(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateChirps([]string{"first", "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var export, stderr bytes.Buffer
+	code := runCommand("export", []string{"-db-path", source, "-format", "csv"}, nil, &export, &stderr)
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr.String())
+	}
+
+	var stdout bytes.Buffer
+	code = runCommand("import", []string{"-db-path", destination, "-format", "csv", "-"}, bytes.NewReader(export.Bytes()), &stdout, &stderr)
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr.String())
+	}
+	if stdout.String() != "imported 2 chirps\n" {
+		t.Errorf("expected %q | got %q", "imported 2 chirps\n", stdout.String())
+	}
+
+	imported, err := NewDB(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirps, err := imported.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedChirps := []Chirp{{ID: 1, Body: "first"}, {ID: 2, Body: "second"}}
+	if !reflect.DeepEqual(chirps, expectedChirps) {
+		t.Errorf("expected %v | got %v", expectedChirps, chirps)
+	}
+}
+
+func TestCommandImportInvalid(t *testing.T) {
+	t.Setenv("CHIRPY_CONFIG", "")
+	destination := filepath.Join(t.TempDir(), "destination.json")
+
+	input := `{"collection":"chirps","id":1,"body":"fine"}
+{"collection":"chirps","id":2,"body":"` + strings.Repeat("a", chirpMaxLength+1) + `"}
+`
+	var stdout, stderr bytes.Buffer
+	code := runCommand("import", []string{"-db-path", destination, "-"}, strings.NewReader(input), &stdout, &stderr)
+	if code != exitCodeError {
+		t.Fatalf("expected exit code %d | got %d", exitCodeError, code)
+	}
+	if !strings.Contains(stderr.String(), "line 2: Chirp is too long") {
+		t.Errorf("expected the error of line 2 | got %s", stderr.String())
+	}
+
+	// (!) nothing is written when a single line is invalid
+	db, err := NewDB(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirps, err := db.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 0 {
+		t.Errorf("expected no chirps | got %v", chirps)
+	}
+}
+
+func TestCommandUsage(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "import", args: []string{}},
+		{name: "import", args: []string{"-format", "xml", "-"}},
+		{name: "export", args: []string{"-format", "xml"}},
+		{name: "export", args: []string{"-bogus"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name+" "+strings.Join(tc.args, " "), func(t *testing.T) {
+			code := runCommand(tc.name, append([]string{"-db-path", filepath.Join(t.TempDir(), "db.json")}, tc.args...), nil, &bytes.Buffer{}, &bytes.Buffer{})
+			if code != exitCodeUsage {
+				t.Errorf("expected exit code %d | got %d", exitCodeUsage, code)
+			}
+		})
+	}
+}
+
+func TestCommandRestore(t *testing.T) {
+	t.Setenv("CHIRPY_CONFIG", "")
+	dbPath := filepath.Join(t.TempDir(), "database.json")
+	snapshot := filepath.Join(t.TempDir(), "snapshot.json")
+
+	err := os.WriteFile(snapshot, []byte(`{"chirps":{"1":{"id":1,"body":"restored"}}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// db stays open through the restore like a running server would
+	db, err := NewDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateChirps([]string{"overwritten", "gone"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand("restore", []string{"-db-path", dbPath, snapshot}, nil, &stdout, &stderr)
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr.String())
+	}
+
+	chirps, err := db.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedChirps := []Chirp{{ID: 1, Body: "restored"}}
+	if !reflect.DeepEqual(chirps, expectedChirps) {
+		t.Errorf("expected %v | got %v", expectedChirps, chirps)
+	}
+
+	// (!) the ids handed out before the restore stay used, chirp 2 may still be linked to
+	chirp, err := db.CreateChirp("after the restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chirp.ID != 3 {
+		t.Errorf("expected id 3 | got %d", chirp.ID)
+	}
+	expectedChirps = append(expectedChirps, chirp)
+
+	// (!) an invalid snapshot leaves the database alone
+	err = os.WriteFile(snapshot, []byte(`{"chirps":{"1":{"id":2,"body":"mismatch"}}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code = runCommand("restore", []string{"-db-path", dbPath, snapshot}, nil, &stdout, &stderr)
+	if code != exitCodeError {
+		t.Errorf("expected exit code %d | got %d", exitCodeError, code)
+	}
+	chirps, err = db.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(chirps, expectedChirps) {
+		t.Errorf("expected %v | got %v", expectedChirps, chirps)
+	}
+}
+
+func TestCommandMigrate(t *testing.T) {
+	t.Setenv("CHIRPY_CONFIG", "")
+	dbPath := filepath.Join(t.TempDir(), "database.json")
+	err := os.WriteFile(dbPath, []byte(`{}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand("migrate", []string{"-db-path", dbPath, "-dry-run"}, nil, &stdout, &stderr)
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "would migrate 0 -> 1") {
+		t.Errorf("expected the pending migration | got %s", stdout.String())
+	}
+
+	stdout.Reset()
+	code = runCommand("migrate", []string{"-db-path", dbPath}, nil, &stdout, &stderr)
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "migrated 0 -> 1") {
+		t.Errorf("expected the migration to run | got %s", stdout.String())
+	}
+}
+
+func TestCommandFsck(t *testing.T) {
+	t.Setenv("CHIRPY_CONFIG", "")
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "database.json")
+	broken, err := sealData([]byte(`{"schema_version":2,"chirps":{"1":{"id":1,"body":"a"},"2":{"id":1,"body":"b"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(dbPath, broken, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := runCommand("fsck", []string{"-db-path", dbPath}, nil, &stdout, &stderr)
+	if code != exitCodeError {
+		t.Fatalf("expected exit code %d | got %d", exitCodeError, code)
+	}
+	if !strings.Contains(stdout.String(), "chirp stored under key 2 has id 1") {
+		t.Errorf("expected the mismatch to be reported | got %s", stdout.String())
+	}
+
+	stdout.Reset()
+	code = runCommand("fsck", []string{"-db-path", dbPath, "-repair"}, nil, &stdout, &stderr)
+	if code != exitCodeOK {
+		t.Fatalf("expected exit code %d | got %d: %s", exitCodeOK, code, stderr.String())
+	}
+	originals, err := filepath.Glob(dbPath + ".fsck-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(originals) != 1 {
+		t.Errorf("expected the original to be kept | got %v", originals)
+	}
+
+	stdout.Reset()
+	code = runCommand("fsck", []string{"-db-path", dbPath}, nil, &stdout, &stderr)
+	if code != exitCodeOK || !strings.Contains(stdout.String(), "ok, 2 chirps") {
+		t.Errorf("expected a clean database after the repair | got %d %s", code, stdout.String())
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressedContentTypes are already compressed, compressing them again only burns CPU
+var compressedContentTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"image/avif",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/pdf",
+}
+
+// encoders are the content codings we can produce, in order of preference when the client likes them equally
+var encoders = []struct {
+	name string
+	pool *sync.Pool
+}{
+	{
+		name: "gzip",
+		pool: &sync.Pool{New: func() any { return gzip.NewWriter(io.Discard) }},
+	},
+	{
+		name: "deflate",
+		pool: &sync.Pool{New: func() any {
+			// (!) NewWriter only fails for an invalid level
+			fw, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+			return fw
+		}},
+	},
+}
+
+// resettableWriter is implemented by both *gzip.Writer and *flate.Writer
+type resettableWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// addVary adds value to the Vary header unless it's already there,
+// the static handler and the compress middleware both vary on Accept-Encoding
+func addVary(header http.Header, value string) {
+	for _, line := range header.Values("Vary") {
+		for _, existing := range strings.Split(line, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), value) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", value)
+}
+
+// middlewareCompress compresses responses of at least minSize bytes with gzip or deflate,
+// whichever the client prefers according to Accept-Encoding
+func middlewareCompress(minSize int) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// (!) the response depends on Accept-Encoding even when we end up not compressing it
+			addVary(w.Header(), "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			// a compressed body would break byte ranges, which refer to the uncompressed file
+			if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressResponseWriter{
+				ResponseWriter: w,
+				encoding:       encoding,
+				pool:           encoderPool(encoding),
+				minSize:        minSize,
+			}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks the encoding with the highest q-value from an Accept-Encoding header
+// it returns "" when the client wants the body as is
+func negotiateEncoding(acceptEncoding string) string {
+	qValues := parseAcceptEncoding(acceptEncoding)
+
+	best, bestQ := "", 0.0
+	for _, encoder := range encoders {
+		q := encodingQValue(qValues, encoder.name)
+		if q > bestQ {
+			best, bestQ = encoder.name, q
+		}
+	}
+	return best
+}
+
+// acceptsEncoding reports whether the client accepts the encoding at all
+func acceptsEncoding(acceptEncoding, encoding string) bool {
+	return encodingQValue(parseAcceptEncoding(acceptEncoding), encoding) > 0
+}
+
+// parseAcceptEncoding maps every coding in an Accept-Encoding header to its q-value
+func parseAcceptEncoding(acceptEncoding string) map[string]float64 {
+	qValues := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		qValues[name] = q
+	}
+	return qValues
+}
+
+// encodingQValue falls back to the "*" wildcard for codings the client didn't name
+func encodingQValue(qValues map[string]float64, encoding string) float64 {
+	q, ok := qValues[encoding]
+	if !ok {
+		q = qValues["*"]
+	}
+	return q
+}
+
+func encoderPool(encoding string) *sync.Pool {
+	for _, encoder := range encoders {
+		if encoder.name == encoding {
+			return encoder.pool
+		}
+	}
+	return nil
+}
+
+// compressResponseWriter holds back the first minSize bytes so small responses are sent as is,
+// after that everything goes through the compressor
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	pool     *sync.Pool
+	minSize  int
+
+	status     int
+	buffer     bytes.Buffer
+	decided    bool
+	compressor resettableWriter
+}
+
+func (cw *compressResponseWriter) WriteHeader(status int) {
+	// (!) 1xx responses are sent straight away, they don't end the response
+	if status >= 100 && status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressResponseWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	if !cw.decided {
+		cw.buffer.Write(b)
+		if cw.buffer.Len() < cw.minSize {
+			return len(b), nil
+		}
+		err := cw.decide(true)
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if cw.compressor != nil {
+		return cw.compressor.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide sends the headers, compressing when the response qualifies and bigEnough is true,
+// then writes out anything that was buffered
+func (cw *compressResponseWriter) decide(bigEnough bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.Header()
+	if header.Get("Content-Type") == "" && cw.buffer.Len() > 0 {
+		// (!) net/http would sniff the type on the first write, we have to do it now to know if it's compressible
+		header.Set("Content-Type", http.DetectContentType(cw.buffer.Bytes()))
+	}
+
+	if bigEnough && cw.compressible() {
+		cw.compressor = cw.pool.Get().(resettableWriter)
+		cw.compressor.Reset(cw.ResponseWriter)
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		// the compressed bytes are not the bytes a strong ETag was computed from
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if cw.buffer.Len() == 0 {
+		return nil
+	}
+	var err error
+	if cw.compressor != nil {
+		_, err = cw.compressor.Write(cw.buffer.Bytes())
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buffer.Bytes())
+	}
+	cw.buffer.Reset()
+	return err
+}
+
+func (cw *compressResponseWriter) compressible() bool {
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	switch cw.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, compressed := range compressedContentTypes {
+		if mediaType == compressed || strings.HasSuffix(compressed, "/") && strings.HasPrefix(mediaType, compressed) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush sends whatever we have so far, streaming handlers always get compressed once they flush
+func (cw *compressResponseWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.compressor != nil {
+		cw.compressor.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the response, a body smaller than minSize is written uncompressed
+func (cw *compressResponseWriter) Close() error {
+	if !cw.decided {
+		// (!) nothing was written at all, net/http sends its implicit 200 by itself
+		if cw.status == 0 && cw.buffer.Len() == 0 {
+			return nil
+		}
+		err := cw.decide(false)
+		if err != nil {
+			return err
+		}
+	}
+	if cw.compressor == nil {
+		return nil
+	}
+
+	err := cw.compressor.Close()
+	// (!) drop the reference to the ResponseWriter before the compressor goes back in the pool
+	cw.compressor.Reset(io.Discard)
+	cw.pool.Put(cw.compressor)
+	cw.compressor = nil
+	return err
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter
+func (cw *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
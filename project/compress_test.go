@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	testCases := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"br, zstd", ""},
+		{"*", "gzip"},
+		{"gzip;q=0, *;q=0.1", "deflate"},
+		{"identity", ""},
+	}
+
+	for _, tc := range testCases {
+		actual := negotiateEncoding(tc.acceptEncoding)
+		if actual != tc.expected {
+			t.Errorf("%q: expected %q | got %q", tc.acceptEncoding, tc.expected, actual)
+		}
+	}
+}
+
+func TestMiddlewareCompress(t *testing.T) {
+	bigJSON := `[` + strings.Repeat(`{"id":1,"body":"I had something interesting for breakfast"},`, 50) + `{}]`
+
+	testCases := []struct {
+		name             string
+		acceptEncoding   string
+		contentType      string
+		body             string
+		expectedEncoding string
+	}{
+		{name: "gzip", acceptEncoding: "gzip", contentType: "application/json", body: bigJSON, expectedEncoding: "gzip"},
+		{name: "deflate", acceptEncoding: "deflate", contentType: "application/json", body: bigJSON, expectedEncoding: "deflate"},
+		{name: "sniffed content type", acceptEncoding: "gzip", body: "<html>" + bigJSON, expectedEncoding: "gzip"},
+		{name: "below threshold", acceptEncoding: "gzip", contentType: "application/json", body: `{"id":1}`, expectedEncoding: ""},
+		{name: "already compressed", acceptEncoding: "gzip", contentType: "image/png", body: bigJSON, expectedEncoding: ""},
+		{name: "not accepted", acceptEncoding: "", contentType: "application/json", body: bigJSON, expectedEncoding: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := middlewareCompress(256)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tc.contentType != "" {
+					w.Header().Set("Content-Type", tc.contentType)
+				}
+				w.WriteHeader(http.StatusCreated)
+				// (!) several small writes, the threshold is about the whole body
+				for _, chunk := range strings.SplitAfter(tc.body, ",") {
+					w.Write([]byte(chunk))
+				}
+			}))
+
+			request := httptest.NewRequest("GET", "/api/chirps", nil)
+			if tc.acceptEncoding != "" {
+				request.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusCreated {
+				t.Errorf("expected status code %d | got %d", http.StatusCreated, recorder.Code)
+			}
+			if recorder.Header().Get("Vary") != "Accept-Encoding" {
+				t.Errorf("expected Vary %s | got %s", "Accept-Encoding", recorder.Header().Get("Vary"))
+			}
+			if recorder.Header().Get("Content-Encoding") != tc.expectedEncoding {
+				t.Fatalf("expected Content-Encoding %q | got %q", tc.expectedEncoding, recorder.Header().Get("Content-Encoding"))
+			}
+
+			var reader io.Reader = recorder.Body
+			switch tc.expectedEncoding {
+			case "gzip":
+				gzipReader, err := gzip.NewReader(recorder.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				reader = gzipReader
+			case "deflate":
+				reader = flate.NewReader(recorder.Body)
+			}
+			body, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(body, []byte(tc.body)) {
+				t.Errorf("expected the body to survive the round trip | got %q", body)
+			}
+		})
+	}
+}
+
+func TestMiddlewareCompressStaticFiles(t *testing.T) {
+	handler := middlewareCompress(0)(http.FileServer(http.Dir(".")))
+
+	// PNGs are already compressed
+	request := httptest.NewRequest("GET", "/assets/logo.png", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Header().Get("Content-Encoding") != "" {
+		t.Errorf("expected a PNG to be sent as is | got %s", recorder.Header().Get("Content-Encoding"))
+	}
+	if recorder.Header().Get("Content-Length") != "35672" {
+		t.Errorf("expected Content-Length %s | got %s", "35672", recorder.Header().Get("Content-Length"))
+	}
+
+	// HTML compresses, and the uncompressed Content-Length must not leak through
+	request = httptest.NewRequest("GET", "/", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("expected Content-Encoding %s | got %s", "gzip", recorder.Header().Get("Content-Encoding"))
+	}
+	if recorder.Header().Get("Content-Length") != "" {
+		t.Errorf("expected no Content-Length | got %s", recorder.Header().Get("Content-Length"))
+	}
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// envPrefix is prepended to the upper snake case flag name to get the environment variable
+// e.g. -db-path is read from CHIRPY_DB_PATH
+const envPrefix = "CHIRPY_"
+
+const redacted = "[REDACTED]"
+
+// Config holds every setting the server needs
+// values are resolved with this precedence, highest first:
+//  1. command-line flags
+//  2. environment variables (CHIRPY_*)
+//  3. the JSON config file given by -config or CHIRPY_CONFIG
+//  4. the defaults from DefaultConfig
+type Config struct {
+	Addr   string `json:"addr"`
+	DBPath string `json:"db_path"`
+	// how long a read or write waits while another process holds the database lock
+	DBLockTimeout Duration `json:"db_lock_timeout"`
+	// (!) secret - base64 AES-256 key, when set the database is encrypted with it on every write
+	// the old keys only decrypt, keep the previous key there until the file has been written once
+	// they're only read from CHIRPY_DB_ENCRYPTION_KEY and the config file, LoadConfig refuses the flags because ps shows them
+	DBEncryptionKey     string     `json:"db_encryption_key"`
+	DBEncryptionOldKeys StringList `json:"db_encryption_old_keys"`
+	// a plaintext file is refused once there's a key, set this only to encrypt an existing database and unset it again
+	DBEncryptionAllowPlaintext bool `json:"db_encryption_allow_plaintext"`
+	// /app serves the files embedded in the binary unless StaticFromDisk is set
+	StaticFromDisk bool   `json:"static_from_disk"`
+	StaticRoot     string `json:"static_root"`
+	// Cache-Control for the files under /app, the first rule whose regular expression matches the path wins
+	StaticCacheRules CacheRules `json:"static_cache_rules"`
+	// directory listings, a custom 404 page (a path under /app) and the single page app fallback for /app
+	StaticDirListing   bool   `json:"static_dir_listing"`
+	StaticNotFoundPage string `json:"static_not_found_page"`
+	StaticSPA          bool   `json:"static_spa"`
+	LogFormat          string `json:"log_format"`
+
+	// ConfigPath is the file LoadConfig read the settings from, it's not a setting itself
+	ConfigPath string `json:"-"`
+
+	// the Combined Log Format access log is disabled when AccessLogPath is empty
+	AccessLogPath           string   `json:"access_log_path"`
+	AccessLogMaxSize        int64    `json:"access_log_max_size"`
+	AccessLogRotateInterval Duration `json:"access_log_rotate_interval"`
+	AccessLogMaxBackups     int      `json:"access_log_max_backups"`
+
+	// how long in-flight requests get to finish once we are asked to stop
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+
+	// limits that stop a slow or huge client from tying up the server
+	ReadHeaderTimeout Duration `json:"read_header_timeout"`
+	ReadTimeout       Duration `json:"read_timeout"`
+	WriteTimeout      Duration `json:"write_timeout"`
+	IdleTimeout       Duration `json:"idle_timeout"`
+	MaxHeaderBytes    int      `json:"max_header_bytes"`
+	MaxBodyBytes      int64    `json:"max_body_bytes"`
+
+	// HTTPS is served when TLSCertFile is set, the files are reloaded when they change on disk
+	TLSCertFile     string `json:"tls_cert_file"`
+	TLSKeyFile      string `json:"tls_key_file"`
+	TLSMinVersion   string `json:"tls_min_version"`
+	TLSCipherPolicy string `json:"tls_cipher_policy"`
+	// an optional plain HTTP listener that redirects everything to HTTPS
+	HTTPRedirectAddr string `json:"http_redirect_addr"`
+
+	// accept HTTP/2 over cleartext (h2c) alongside HTTP/1.1
+	H2C                       bool `json:"h2c"`
+	HTTP2MaxConcurrentStreams int  `json:"http2_max_concurrent_streams"`
+
+	// token bucket rate limits for POST /api/chirps, a rate of 0 disables the limit
+	RateLimitIPRate    float64 `json:"rate_limit_ip_rate"`
+	RateLimitIPBurst   int     `json:"rate_limit_ip_burst"`
+	RateLimitUserRate  float64 `json:"rate_limit_user_rate"`
+	RateLimitUserBurst int     `json:"rate_limit_user_burst"`
+
+	// CORS for browser clients on /api, disabled while CORSAllowedOrigins is empty
+	CORSAllowedOrigins   StringList `json:"cors_allowed_origins"`
+	CORSAllowedMethods   StringList `json:"cors_allowed_methods"`
+	CORSAllowedHeaders   StringList `json:"cors_allowed_headers"`
+	CORSExposedHeaders   StringList `json:"cors_exposed_headers"`
+	CORSAllowCredentials bool       `json:"cors_allow_credentials"`
+	CORSMaxAge           Duration   `json:"cors_max_age"`
+
+	// gzip/deflate response compression, responses smaller than CompressionMinSize are sent as is
+	Compression        bool `json:"compression"`
+	CompressionMinSize int  `json:"compression_min_size"`
+
+	// snapshots taken by POST /api/admin/backup, only the newest BackupKeep are kept (0 keeps them all)
+	// and a new one is refused until BackupMinInterval has passed since the last (0 disables the check)
+	BackupDir         string   `json:"backup_dir"`
+	BackupGzip        bool     `json:"backup_gzip"`
+	BackupKeep        int      `json:"backup_keep"`
+	BackupMinInterval Duration `json:"backup_min_interval"`
+
+	// deleted chirps can be restored for TrashRetention, the purger removes them for good after that
+	TrashRetention     Duration `json:"trash_retention"`
+	TrashPurgeInterval Duration `json:"trash_purge_interval"`
+
+	// (!) secret - the admin routes require `Authorization: Bearer <AdminToken>` and are disabled when it's empty
+	AdminToken string `json:"admin_token"`
+}
+
+// DefaultConfig returns the configuration used when nothing else is set
+func DefaultConfig() Config {
+	return Config{
+		Addr:       ":8080",
+		DBPath:     "database.json",
+		StaticRoot: ".",
+		LogFormat:  "text",
+
+		DBLockTimeout: Duration{defaultLockTimeout},
+
+		StaticCacheRules: DefaultCacheRules(),
+
+		AccessLogPath:           "",
+		AccessLogMaxSize:        100 << 20, // 100 MiB
+		AccessLogRotateInterval: Duration{24 * time.Hour},
+		AccessLogMaxBackups:     7,
+
+		ShutdownTimeout: Duration{10 * time.Second},
+
+		ReadHeaderTimeout: Duration{5 * time.Second},
+		ReadTimeout:       Duration{15 * time.Second},
+		WriteTimeout:      Duration{30 * time.Second},
+		IdleTimeout:       Duration{2 * time.Minute},
+		MaxHeaderBytes:    64 << 10, // 64 KiB
+		MaxBodyBytes:      1 << 20,  // 1 MiB
+
+		TLSMinVersion:   "1.2",
+		TLSCipherPolicy: "intermediate",
+
+		H2C:                       false,
+		HTTP2MaxConcurrentStreams: 250,
+
+		RateLimitIPRate:    1,
+		RateLimitIPBurst:   10,
+		RateLimitUserRate:  1,
+		RateLimitUserBurst: 10,
+
+		CORSAllowedMethods: StringList{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+		CORSAllowedHeaders: StringList{"Authorization", "Content-Type", headerRequestID},
+		CORSExposedHeaders: StringList{headerRequestID, "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+		CORSMaxAge:         Duration{10 * time.Minute},
+
+		Compression:        true,
+		CompressionMinSize: 1024,
+
+		BackupDir:         "backups",
+		BackupGzip:        true,
+		BackupKeep:        7,
+		BackupMinInterval: Duration{time.Minute},
+
+		TrashRetention:     Duration{30 * 24 * time.Hour},
+		TrashPurgeInterval: Duration{time.Hour},
+	}
+}
+
+// registerConfigFlags binds a flag for every Config field to cfg
+// the flag names are also used to derive the environment variable names
+func registerConfigFlags(fs *flag.FlagSet, cfg *Config) {
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "`host:port` to listen on")
+	fs.StringVar(&cfg.DBPath, "db-path", cfg.DBPath, "path to the JSON database file")
+	fs.Var(&cfg.DBLockTimeout, "db-lock-timeout", "how long to wait while another process holds the database lock")
+	fs.StringVar(&cfg.DBEncryptionKey, "db-encryption-key", cfg.DBEncryptionKey, "base64 AES-256 key to encrypt the database with (plaintext when empty), generate one with `openssl rand -base64 32`; only read from CHIRPY_DB_ENCRYPTION_KEY or the config file, the flag is refused")
+	fs.Var(&cfg.DBEncryptionOldKeys, "db-encryption-old-keys", "comma separated keys the database may still be encrypted with, it's re-encrypted with db-encryption-key on the next write; only read from CHIRPY_DB_ENCRYPTION_OLD_KEYS or the config file, the flag is refused")
+	fs.BoolVar(&cfg.DBEncryptionAllowPlaintext, "db-encryption-allow-plaintext", cfg.DBEncryptionAllowPlaintext, "accept a plaintext database despite db-encryption-key, only to encrypt an existing database once")
+	fs.BoolVar(&cfg.StaticFromDisk, "static-from-disk", cfg.StaticFromDisk, "serve /app/ from -static-root instead of the files embedded in the binary (for development)")
+	fs.StringVar(&cfg.StaticRoot, "static-root", cfg.StaticRoot, "directory served under /app/ with -static-from-disk")
+	fs.Var(&cfg.StaticCacheRules, "static-cache-rules", "JSON array of {\"pattern\": regexp, \"cache_control\": value} for the files under /app/, first match wins")
+	fs.BoolVar(&cfg.StaticDirListing, "static-dir-listing", cfg.StaticDirListing, "list the contents of directories under /app/ that have no index.html (off by default, listings reveal every file)")
+	fs.StringVar(&cfg.StaticNotFoundPage, "static-not-found-page", cfg.StaticNotFoundPage, "page under /app/ served for missing files, e.g. 404.html")
+	fs.BoolVar(&cfg.StaticSPA, "static-spa", cfg.StaticSPA, "serve index.html for missing paths under /app/ without a file extension")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "application log format, \"text\" or \"json\"")
+
+	fs.StringVar(&cfg.AccessLogPath, "access-log-path", cfg.AccessLogPath, "write a Combined Log Format access log to this file (disabled when empty)")
+	fs.Int64Var(&cfg.AccessLogMaxSize, "access-log-max-size", cfg.AccessLogMaxSize, "rotate the access log after this many bytes (0 disables)")
+	fs.Var(&cfg.AccessLogRotateInterval, "access-log-rotate-interval", "rotate the access log this often (0 disables)")
+	fs.IntVar(&cfg.AccessLogMaxBackups, "access-log-max-backups", cfg.AccessLogMaxBackups, "number of rotated access logs to keep (0 keeps all)")
+
+	fs.Var(&cfg.ShutdownTimeout, "shutdown-timeout", "how long in-flight requests get to finish on shutdown")
+
+	fs.Var(&cfg.ReadHeaderTimeout, "read-header-timeout", "maximum time to read request headers")
+	fs.Var(&cfg.ReadTimeout, "read-timeout", "maximum time to read the whole request, including the body")
+	fs.Var(&cfg.WriteTimeout, "write-timeout", "maximum time to write the response")
+	fs.Var(&cfg.IdleTimeout, "idle-timeout", "how long to keep an idle keep-alive connection open")
+	fs.IntVar(&cfg.MaxHeaderBytes, "max-header-bytes", cfg.MaxHeaderBytes, "maximum size of the request headers in bytes")
+	fs.Int64Var(&cfg.MaxBodyBytes, "max-body-bytes", cfg.MaxBodyBytes, "maximum size of a request body in bytes, larger bodies get a 413")
+
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert-file", cfg.TLSCertFile, "PEM certificate chain, serves HTTPS when set")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key-file", cfg.TLSKeyFile, "PEM private key for -tls-cert-file")
+	fs.StringVar(&cfg.TLSMinVersion, "tls-min-version", cfg.TLSMinVersion, "minimum TLS version, \"1.2\" or \"1.3\"")
+	fs.StringVar(&cfg.TLSCipherPolicy, "tls-cipher-policy", cfg.TLSCipherPolicy, "TLS 1.2 cipher suites, \"intermediate\" (forward secret AEAD only) or \"default\" (Go's defaults)")
+	fs.StringVar(&cfg.HTTPRedirectAddr, "http-redirect-addr", cfg.HTTPRedirectAddr, "also listen for plain HTTP on this address and redirect to HTTPS")
+
+	fs.BoolVar(&cfg.H2C, "h2c", cfg.H2C, "accept HTTP/2 over cleartext (prior knowledge) alongside HTTP/1.1")
+	fs.IntVar(&cfg.HTTP2MaxConcurrentStreams, "http2-max-concurrent-streams", cfg.HTTP2MaxConcurrentStreams, "maximum concurrent HTTP/2 streams per connection")
+
+	fs.Float64Var(&cfg.RateLimitIPRate, "rate-limit-ip-rate", cfg.RateLimitIPRate, "chirps per second a client IP may post on average (0 disables)")
+	fs.IntVar(&cfg.RateLimitIPBurst, "rate-limit-ip-burst", cfg.RateLimitIPBurst, "chirps a client IP may post in a burst")
+	fs.Float64Var(&cfg.RateLimitUserRate, "rate-limit-user-rate", cfg.RateLimitUserRate, "chirps per second an authenticated user may post on average (0 disables)")
+	fs.IntVar(&cfg.RateLimitUserBurst, "rate-limit-user-burst", cfg.RateLimitUserBurst, "chirps an authenticated user may post in a burst")
+
+	fs.Var(&cfg.CORSAllowedOrigins, "cors-allowed-origins", "comma separated origins allowed to call /api, \"*\" allows any without credentials (CORS is off when empty)")
+	fs.Var(&cfg.CORSAllowedMethods, "cors-allowed-methods", "comma separated methods cross-origin requests may use")
+	fs.Var(&cfg.CORSAllowedHeaders, "cors-allowed-headers", "comma separated request headers cross-origin requests may send, \"*\" allows any")
+	fs.Var(&cfg.CORSExposedHeaders, "cors-exposed-headers", "comma separated response headers cross-origin scripts may read")
+	fs.BoolVar(&cfg.CORSAllowCredentials, "cors-allow-credentials", cfg.CORSAllowCredentials, "allow cross-origin requests with cookies or HTTP authentication")
+	fs.Var(&cfg.CORSMaxAge, "cors-max-age", "how long browsers may cache a preflight response")
+
+	fs.BoolVar(&cfg.Compression, "compression", cfg.Compression, "compress responses with gzip or deflate when the client accepts it")
+	fs.IntVar(&cfg.CompressionMinSize, "compression-min-size", cfg.CompressionMinSize, "only compress responses of at least this many bytes")
+
+	fs.StringVar(&cfg.BackupDir, "backup-dir", cfg.BackupDir, "directory POST /api/admin/backup writes snapshots of the database to")
+	fs.BoolVar(&cfg.BackupGzip, "backup-gzip", cfg.BackupGzip, "gzip backups unless the request asks otherwise with ?gzip=false")
+	fs.IntVar(&cfg.BackupKeep, "backup-keep", cfg.BackupKeep, "number of backups to keep, older ones are deleted (0 keeps them all)")
+	fs.Var(&cfg.BackupMinInterval, "backup-min-interval", "refuse a backup until this long after the last one, so requests can't prune every older backup (0 disables)")
+
+	fs.Var(&cfg.TrashRetention, "trash-retention", "how long deleted chirps can be restored before they're purged")
+	fs.Var(&cfg.TrashPurgeInterval, "trash-purge-interval", "how often chirps past the trash retention are purged")
+
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "bearer token required by /api/admin/* and the other admin routes (disabled when empty)")
+}
+
+// envName maps a flag name to its environment variable
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// commandLineSecrets are the flags that only exist to derive environment variable names,
+// setting them on the command line is an error
+var commandLineSecrets = []string{"db-encryption-key", "db-encryption-old-keys"}
+
+// LoadConfig resolves the configuration from args (without the program name), the environment and a config file
+// printConfig reports whether -print-config was passed
+func LoadConfig(args []string, lookupEnv func(string) (string, bool), output io.Writer) (cfg Config, printConfig bool, err error) {
+	// (!) the command line is parsed into a throwaway Config first,
+	// we only need it to find the config file and to know which flags were set explicitly
+	cliCfg := DefaultConfig()
+	cli := flag.NewFlagSet("chirpy", flag.ContinueOnError)
+	cli.SetOutput(output)
+	registerConfigFlags(cli, &cliCfg)
+	configPath := cli.String("config", "", "path to a JSON config file (env "+envName("config")+")")
+	cli.BoolVar(&printConfig, "print-config", false, "print the resolved configuration as JSON and exit")
+	cli.Usage = func() {
+		fmt.Fprintf(cli.Output(), "Usage of chirpy:\n\n")
+		fmt.Fprintf(cli.Output(), "Settings are resolved from flags, then %s* environment variables, then the -config file, then defaults.\n", envPrefix)
+		fmt.Fprintf(cli.Output(), "Every flag -some-name can also be set with the environment variable %s.\n\n", envName("some-name"))
+		fmt.Fprintf(cli.Output(), "Maintenance commands: %s (run chirpy <command> -h for their flags)\n\n", strings.Join(commandNames(), ", "))
+		cli.PrintDefaults()
+	}
+	err = cli.Parse(args)
+	if err != nil {
+		return Config{}, false, err
+	}
+	if cli.NArg() > 0 {
+		return Config{}, false, fmt.Errorf("unexpected arguments: %s", strings.Join(cli.Args(), " "))
+	}
+	// (!) every user on the machine can read the command line through ps and /proc
+	cli.Visit(func(f *flag.Flag) {
+		if slices.Contains(commandLineSecrets, f.Name) && err == nil {
+			err = fmt.Errorf("-%s is not accepted on the command line where ps shows it, set %s or put it in the -config file", f.Name, envName(f.Name))
+		}
+	})
+	if err != nil {
+		return Config{}, false, err
+	}
+
+	cfg = DefaultConfig()
+
+	path, _ := lookupEnv(envName("config"))
+	if *configPath != "" {
+		path = *configPath
+	}
+	if path != "" {
+		err = loadConfigFile(path, &cfg)
+		if err != nil {
+			return Config{}, false, err
+		}
+		cfg.ConfigPath = path
+	}
+
+	resolved := flag.NewFlagSet("resolved", flag.ContinueOnError)
+	resolved.SetOutput(io.Discard)
+	registerConfigFlags(resolved, &cfg)
+
+	resolved.VisitAll(func(f *flag.Flag) {
+		value, ok := lookupEnv(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		setErr := resolved.Set(f.Name, value)
+		if setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), setErr)
+		}
+	})
+	if err != nil {
+		return Config{}, false, err
+	}
+
+	cli.Visit(func(f *flag.Flag) {
+		if resolved.Lookup(f.Name) == nil || err != nil {
+			return
+		}
+		err = resolved.Set(f.Name, f.Value.String())
+	})
+	if err != nil {
+		return Config{}, false, err
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return Config{}, false, err
+	}
+
+	return cfg, printConfig, nil
+}
+
+// loadConfigFile overlays the JSON file at path onto cfg, unknown keys are an error
+func loadConfigFile(path string, cfg *Config) error {
+	byteData, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(byteData))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(cfg)
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once
+func (cfg Config) Validate() error {
+	var errs []error
+
+	_, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("addr: %w", err))
+	}
+
+	if cfg.DBPath == "" {
+		errs = append(errs, errors.New("db_path: must not be empty"))
+	}
+	if cfg.DBLockTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("db_lock_timeout: must be positive"))
+	}
+	_, err = cfg.dbKeys()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.StaticFromDisk {
+		info, err := os.Stat(cfg.StaticRoot)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("static_root: %w", err))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("static_root: %s is not a directory", cfg.StaticRoot))
+		}
+	}
+
+	_, err = cfg.StaticCacheRules.compile()
+	if err != nil {
+		errs = append(errs, fmt.Errorf("static_cache_rules: %w", err))
+	}
+
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("log_format: must be \"text\" or \"json\", got %q", cfg.LogFormat))
+	}
+
+	if cfg.AccessLogMaxSize < 0 {
+		errs = append(errs, errors.New("access_log_max_size: must not be negative"))
+	}
+	if cfg.AccessLogRotateInterval.Duration < 0 {
+		errs = append(errs, errors.New("access_log_rotate_interval: must not be negative"))
+	}
+	if cfg.AccessLogMaxBackups < 0 {
+		errs = append(errs, errors.New("access_log_max_backups: must not be negative"))
+	}
+
+	if cfg.ShutdownTimeout.Duration <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout: must be positive"))
+	}
+
+	// (!) a zero timeout means "no timeout" to net/http, which is exactly what we want to avoid
+	timeouts := []struct {
+		name  string
+		value Duration
+	}{
+		{"read_header_timeout", cfg.ReadHeaderTimeout},
+		{"read_timeout", cfg.ReadTimeout},
+		{"write_timeout", cfg.WriteTimeout},
+		{"idle_timeout", cfg.IdleTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be positive", timeout.name))
+		}
+	}
+	if cfg.MaxHeaderBytes <= 0 {
+		errs = append(errs, errors.New("max_header_bytes: must be positive"))
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		errs = append(errs, errors.New("max_body_bytes: must be positive"))
+	}
+
+	if cfg.HTTP2MaxConcurrentStreams <= 0 {
+		errs = append(errs, errors.New("http2_max_concurrent_streams: must be positive"))
+	}
+
+	rateLimits := []struct {
+		name  string
+		rate  float64
+		burst int
+	}{
+		{"rate_limit_ip", cfg.RateLimitIPRate, cfg.RateLimitIPBurst},
+		{"rate_limit_user", cfg.RateLimitUserRate, cfg.RateLimitUserBurst},
+	}
+	for _, rateLimit := range rateLimits {
+		if rateLimit.rate < 0 {
+			errs = append(errs, fmt.Errorf("%s_rate: must not be negative", rateLimit.name))
+		}
+		if rateLimit.rate > 0 && rateLimit.burst < 1 {
+			errs = append(errs, fmt.Errorf("%s_burst: must be at least 1", rateLimit.name))
+		}
+	}
+
+	if cfg.CORSMaxAge.Duration < 0 {
+		errs = append(errs, errors.New("cors_max_age: must not be negative"))
+	}
+	if cfg.CORSAllowCredentials && slices.Contains(cfg.CORSAllowedOrigins, "*") {
+		errs = append(errs, errors.New("cors_allowed_origins, cors_allow_credentials: \"*\" can't be combined with credentials, list the origins instead"))
+	}
+	for _, method := range cfg.CORSAllowedMethods {
+		if method != strings.ToUpper(method) {
+			errs = append(errs, fmt.Errorf("cors_allowed_methods: methods are case sensitive, use %q", strings.ToUpper(method)))
+		}
+	}
+
+	if cfg.CompressionMinSize < 0 {
+		errs = append(errs, errors.New("compression_min_size: must not be negative"))
+	}
+
+	if cfg.BackupDir == "" {
+		errs = append(errs, errors.New("backup_dir: must not be empty"))
+	}
+	if cfg.BackupKeep < 0 {
+		errs = append(errs, errors.New("backup_keep: must not be negative"))
+	}
+	if cfg.BackupMinInterval.Duration < 0 {
+		errs = append(errs, errors.New("backup_min_interval: must not be negative"))
+	}
+
+	if cfg.TrashRetention.Duration <= 0 {
+		errs = append(errs, errors.New("trash_retention: must be positive"))
+	}
+	if cfg.TrashPurgeInterval.Duration <= 0 {
+		errs = append(errs, errors.New("trash_purge_interval: must be positive"))
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls_cert_file, tls_key_file: must be set together"))
+	}
+	if _, ok := tlsVersions[cfg.TLSMinVersion]; !ok {
+		errs = append(errs, fmt.Errorf("tls_min_version: must be \"1.2\" or \"1.3\", got %q", cfg.TLSMinVersion))
+	}
+	if cfg.TLSCipherPolicy != "intermediate" && cfg.TLSCipherPolicy != "default" {
+		errs = append(errs, fmt.Errorf("tls_cipher_policy: must be \"intermediate\" or \"default\", got %q", cfg.TLSCipherPolicy))
+	}
+	if cfg.HTTPRedirectAddr != "" {
+		if cfg.TLSCertFile == "" {
+			errs = append(errs, errors.New("http_redirect_addr: requires tls_cert_file"))
+		}
+		_, _, err := net.SplitHostPort(cfg.HTTPRedirectAddr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("http_redirect_addr: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of cfg that is safe to print or log
+func (cfg Config) Redacted() Config {
+	if cfg.AdminToken != "" {
+		cfg.AdminToken = redacted
+	}
+	if cfg.DBEncryptionKey != "" {
+		cfg.DBEncryptionKey = redacted
+	}
+	if len(cfg.DBEncryptionOldKeys) > 0 {
+		oldKeys := make(StringList, len(cfg.DBEncryptionOldKeys))
+		for i := range oldKeys {
+			oldKeys[i] = redacted
+		}
+		cfg.DBEncryptionOldKeys = oldKeys
+	}
+	return cfg
+}
+
+// dbKeys parses the database encryption keys, nil means the database is stored in plaintext
+func (cfg Config) dbKeys() (*dbKeys, error) {
+	keys, err := newDBKeys(cfg.DBEncryptionKey, cfg.DBEncryptionOldKeys)
+	if keys != nil {
+		keys.allowPlaintext = cfg.DBEncryptionAllowPlaintext
+	}
+	return keys, err
+}
+
+// Duration is a time.Duration that reads and writes "1m30s" style strings
+// in JSON, flags and environment variables
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\": %w", err)
+	}
+	return d.Set(s)
+}
+
+// Set implements flag.Value
+func (d *Duration) Set(s string) error {
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// StringList is a list of strings, written as a JSON array in the config file
+// and as a comma separated value in flags and environment variables
+type StringList []string
+
+func (sl StringList) String() string {
+	return strings.Join(sl, ",")
+}
+
+// Set implements flag.Value, an empty value clears the list
+func (sl *StringList) Set(s string) error {
+	*sl = nil
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			*sl = append(*sl, item)
+		}
+	}
+	return nil
+}
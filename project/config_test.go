@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "chirpy.json")
+	err := os.WriteFile(configPath, []byte(`{"addr": ":1111", "db_path": "file.json", "log_format": "json", "shutdown_timeout": "1m"}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"CHIRPY_CONFIG":  configPath,
+		"CHIRPY_ADDR":    ":2222",
+		"CHIRPY_DB_PATH": "env.json",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	cfg, printConfig, err := LoadConfig([]string{"-addr", ":3333"}, lookupEnv, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if printConfig {
+		t.Errorf("expected printConfig %t | got %t", false, printConfig)
+	}
+	// flag beats env beats file beats default
+	if cfg.Addr != ":3333" {
+		t.Errorf("expected addr %s | got %s", ":3333", cfg.Addr)
+	}
+	if cfg.DBPath != "env.json" {
+		t.Errorf("expected db path %s | got %s", "env.json", cfg.DBPath)
+	}
+	if cfg.LogFormat != "json" {
+		t.Errorf("expected log format %s | got %s", "json", cfg.LogFormat)
+	}
+	if cfg.ShutdownTimeout.Duration != time.Minute {
+		t.Errorf("expected shutdown timeout %s | got %s", time.Minute, cfg.ShutdownTimeout)
+	}
+	if cfg.StaticRoot != DefaultConfig().StaticRoot {
+		t.Errorf("expected static root %s | got %s", DefaultConfig().StaticRoot, cfg.StaticRoot)
+	}
+	if cfg.ConfigPath != configPath {
+		t.Errorf("expected config path %s | got %s", configPath, cfg.ConfigPath)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	noEnv := func(string) (string, bool) { return "", false }
+
+	unknownKeyPath := filepath.Join(t.TempDir(), "chirpy.json")
+	err := os.WriteFile(unknownKeyPath, []byte(`{"port": 8080}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "invalid addr", args: []string{"-addr", "8080"}},
+		{name: "invalid log format", args: []string{"-log-format", "xml"}},
+		{name: "missing static root", args: []string{"-static-from-disk", "-static-root", filepath.Join(t.TempDir(), "missing")}},
+		{name: "invalid duration", args: []string{"-shutdown-timeout", "soon"}},
+		{name: "disabled write timeout", args: []string{"-write-timeout", "0s"}},
+		{name: "negative body limit", args: []string{"-max-body-bytes", "-1"}},
+		{name: "unknown config key", args: []string{"-config", unknownKeyPath}},
+		{name: "extra arguments", args: []string{"serve"}},
+		{name: "encryption key on the command line", args: []string{"-db-encryption-key", testKey(1)}},
+		{name: "old encryption keys on the command line", args: []string{"-db-encryption-old-keys", testKey(1)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := LoadConfig(tc.args, noEnv, io.Discard)
+			if err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	_, _, err = LoadConfig([]string{"-h"}, noEnv, io.Discard)
+	if !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("expected %v | got %v", flag.ErrHelp, err)
+	}
+}
+
+func TestLoadConfigSecretsFromEnv(t *testing.T) {
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := map[string]string{"CHIRPY_DB_ENCRYPTION_KEY": testKey(1)}[key]
+		return value, ok
+	}
+	cfg, _, err := LoadConfig(nil, lookupEnv, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DBEncryptionKey != testKey(1) {
+		t.Errorf("expected the key from the environment | got %q", cfg.DBEncryptionKey)
+	}
+
+	_, _, err = LoadConfig([]string{"-db-encryption-key", testKey(1)}, lookupEnv, io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "CHIRPY_DB_ENCRYPTION_KEY") {
+		t.Errorf("expected the error to point at CHIRPY_DB_ENCRYPTION_KEY | got %v", err)
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "s3cret"
+	cfg.DBEncryptionKey = "s3cret"
+	cfg.DBEncryptionOldKeys = StringList{"old", "older"}
+
+	redactedCfg := cfg.Redacted()
+	if redactedCfg.AdminToken != redacted {
+		t.Errorf("expected admin token %s | got %s", redacted, redactedCfg.AdminToken)
+	}
+	if redactedCfg.DBEncryptionKey != redacted {
+		t.Errorf("expected db encryption key %s | got %s", redacted, redactedCfg.DBEncryptionKey)
+	}
+	if redactedCfg.DBEncryptionOldKeys.String() != redacted+","+redacted {
+		t.Errorf("expected db encryption old keys %s,%s | got %s", redacted, redacted, redactedCfg.DBEncryptionOldKeys)
+	}
+	if cfg.DBEncryptionOldKeys[0] != "old" {
+		t.Error("expected Redacted to leave the original old keys untouched")
+	}
+	if cfg.AdminToken != "s3cret" {
+		t.Error("expected Redacted to leave the original config untouched")
+	}
+}
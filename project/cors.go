@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsProbeMethods are the methods we try against the mux to find out what a path supports
+var corsProbeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// corsPolicy is the Cross-Origin Resource Sharing configuration
+// https://developer.mozilla.org/en-US/docs/Web/HTTP/CORS
+type corsPolicy struct {
+	// AllowedOrigins may contain "*" to allow any origin, but only without credentials
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+func (policy corsPolicy) originAllowed(origin string) bool {
+	return slices.Contains(policy.AllowedOrigins, "*") || slices.Contains(policy.AllowedOrigins, origin)
+}
+
+func (policy corsPolicy) headerAllowed(header string) bool {
+	if slices.Contains(policy.AllowedHeaders, "*") {
+		return true
+	}
+	return slices.ContainsFunc(policy.AllowedHeaders, func(allowed string) bool {
+		return strings.EqualFold(allowed, header)
+	})
+}
+
+// middlewareCORS adds CORS headers to requests under pathPrefix and answers their preflight requests
+// it looks up which methods a path supports on mux, so it has to wrap the whole mux:
+// a method specific pattern like "GET /api/chirps" would otherwise turn every preflight OPTIONS into a 405
+func middlewareCORS(policy corsPolicy, mux *http.ServeMux, pathPrefix string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !strings.HasPrefix(r.URL.Path, pathPrefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// (!) the response depends on Origin so caches must not share it between origins
+			addVary(w.Header(), "Origin")
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !preflight {
+				if policy.originAllowed(origin) {
+					setCORSOriginHeaders(w, policy, origin)
+					if len(policy.ExposedHeaders) > 0 {
+						w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposedHeaders, ", "))
+					}
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			addVary(w.Header(), "Access-Control-Request-Method")
+			addVary(w.Header(), "Access-Control-Request-Headers")
+
+			methods := routeMethods(mux, r)
+			if len(methods) == 0 {
+				// no route lives here, let the mux send its 404
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// (!) a failed preflight is a successful response without the Access-Control-Allow-* headers,
+			// the browser then refuses to send the actual request
+			allowedMethods := slices.DeleteFunc(methods, func(method string) bool {
+				return !slices.Contains(policy.AllowedMethods, method)
+			})
+			requestedMethod := r.Header.Get("Access-Control-Request-Method")
+			requestedHeaders := parseHeaderList(r.Header.Get("Access-Control-Request-Headers"))
+			if !policy.originAllowed(origin) ||
+				!slices.Contains(allowedMethods, requestedMethod) ||
+				slices.ContainsFunc(requestedHeaders, func(header string) bool { return !policy.headerAllowed(header) }) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			setCORSOriginHeaders(w, policy, origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
+			if len(requestedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+			}
+			if policy.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func setCORSOriginHeaders(w http.ResponseWriter, policy corsPolicy, origin string) {
+	if !slices.Contains(policy.AllowedOrigins, origin) {
+		// (!) only "*" matched: send it literally and never with credentials,
+		// echoing the origin back would let any website make requests with the user's credentials
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	if policy.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// routeMethods returns the methods that have a route on mux for the path of r
+func routeMethods(mux *http.ServeMux, r *http.Request) []string {
+	var methods []string
+	for _, method := range corsProbeMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		// (!) the pattern is empty when nothing matches, including when only other methods match
+		_, pattern := mux.Handler(probe)
+		if pattern != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// parseHeaderList splits a comma separated header value like "content-type, authorization"
+func parseHeaderList(value string) []string {
+	var headers []string
+	for _, header := range strings.Split(value, ",") {
+		header = strings.TrimSpace(header)
+		if header != "" {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newCORSTestHandler(t *testing.T, policy corsPolicy) http.Handler {
+	t.Helper()
+
+	ok := http.HandlerFunc(handlerReadiness)
+	mux := http.NewServeMux()
+	handler, err := NewRouter(mux, RouteGroup{
+		Middleware: []Middleware{middlewareCORS(policy, mux, "/api/")},
+		Routes: []Route{
+			{Method: "GET", Path: "/api/chirps", Handler: ok},
+			{Method: "POST", Path: "/api/chirps", Handler: ok},
+			{Method: "GET", Path: "/api/chirps/{chirpID}", Handler: ok},
+			{Path: "/app/", Handler: ok},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestMiddlewareCORSPreflight(t *testing.T) {
+	handler := newCORSTestHandler(t, corsPolicy{
+		AllowedOrigins:   []string{"https://web.example.com"},
+		AllowedMethods:   []string{"GET", "HEAD", "POST", "DELETE"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})
+
+	testCases := []struct {
+		name                 string
+		path                 string
+		origin               string
+		requestMethod        string
+		requestHeaders       string
+		expectedStatusCode   int
+		expectedAllowOrigin  string
+		expectedAllowMethods string
+	}{
+		{
+			name:                 "allowed",
+			path:                 "/api/chirps",
+			origin:               "https://web.example.com",
+			requestMethod:        "POST",
+			requestHeaders:       "content-type, authorization",
+			expectedStatusCode:   http.StatusNoContent,
+			expectedAllowOrigin:  "https://web.example.com",
+			expectedAllowMethods: "GET, HEAD, POST",
+		},
+		{
+			name:                 "wildcard pattern",
+			path:                 "/api/chirps/42",
+			origin:               "https://web.example.com",
+			requestMethod:        "GET",
+			expectedStatusCode:   http.StatusNoContent,
+			expectedAllowOrigin:  "https://web.example.com",
+			expectedAllowMethods: "GET, HEAD",
+		},
+		{
+			name:               "method without a route",
+			path:               "/api/chirps",
+			origin:             "https://web.example.com",
+			requestMethod:      "DELETE",
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:               "origin not allowed",
+			path:               "/api/chirps",
+			origin:             "https://evil.example.com",
+			requestMethod:      "POST",
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:               "header not allowed",
+			path:               "/api/chirps",
+			origin:             "https://web.example.com",
+			requestMethod:      "POST",
+			requestHeaders:     "X-Custom",
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:               "unknown path",
+			path:               "/api/nowhere",
+			origin:             "https://web.example.com",
+			requestMethod:      "GET",
+			expectedStatusCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("OPTIONS", tc.path, nil)
+			request.Header.Set("Origin", tc.origin)
+			request.Header.Set("Access-Control-Request-Method", tc.requestMethod)
+			if tc.requestHeaders != "" {
+				request.Header.Set("Access-Control-Request-Headers", tc.requestHeaders)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d | got %d", tc.expectedStatusCode, recorder.Code)
+			}
+			if recorder.Header().Get("Access-Control-Allow-Origin") != tc.expectedAllowOrigin {
+				t.Errorf("expected allow origin %q | got %q", tc.expectedAllowOrigin, recorder.Header().Get("Access-Control-Allow-Origin"))
+			}
+			if recorder.Header().Get("Access-Control-Allow-Methods") != tc.expectedAllowMethods {
+				t.Errorf("expected allow methods %q | got %q", tc.expectedAllowMethods, recorder.Header().Get("Access-Control-Allow-Methods"))
+			}
+			if tc.expectedAllowOrigin != "" {
+				if recorder.Header().Get("Access-Control-Allow-Credentials") != "true" {
+					t.Error("expected credentials to be allowed")
+				}
+				if recorder.Header().Get("Access-Control-Max-Age") != "600" {
+					t.Errorf("expected max age %s | got %s", "600", recorder.Header().Get("Access-Control-Max-Age"))
+				}
+			}
+		})
+	}
+}
+
+func TestMiddlewareCORSActualRequest(t *testing.T) {
+	handler := newCORSTestHandler(t, corsPolicy{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET"},
+		ExposedHeaders: []string{headerRequestID},
+	})
+
+	request := httptest.NewRequest("GET", "/api/chirps", nil)
+	request.Header.Set("Origin", "https://anywhere.example.com")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status code %d | got %d", http.StatusOK, recorder.Code)
+	}
+	if recorder.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("expected allow origin %s | got %s", "*", recorder.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if recorder.Header().Get("Access-Control-Expose-Headers") != headerRequestID {
+		t.Errorf("expected expose headers %s | got %s", headerRequestID, recorder.Header().Get("Access-Control-Expose-Headers"))
+	}
+	if recorder.Header().Get("Vary") != "Origin" {
+		t.Errorf("expected Vary %s | got %s", "Origin", recorder.Header().Get("Vary"))
+	}
+
+	// paths outside /api/ are left alone
+	request = httptest.NewRequest("GET", "/app/", nil)
+	request.Header.Set("Origin", "https://anywhere.example.com")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("expected no CORS headers outside /api/ | got %s", recorder.Header().Get("Access-Control-Allow-Origin"))
+	}
+}
+
+func TestMiddlewareCORSWildcardCredentials(t *testing.T) {
+	// (!) Validate refuses this policy, the middleware must not honour it either
+	handler := newCORSTestHandler(t, corsPolicy{
+		AllowedOrigins:   []string{"https://web.example.com", "*"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowCredentials: true,
+	})
+
+	for _, tc := range []struct {
+		origin                   string
+		expectedAllowOrigin      string
+		expectedAllowCredentials string
+	}{
+		{origin: "https://web.example.com", expectedAllowOrigin: "https://web.example.com", expectedAllowCredentials: "true"},
+		{origin: "https://evil.example.com", expectedAllowOrigin: "*", expectedAllowCredentials: ""},
+	} {
+		for _, preflight := range []bool{false, true} {
+			request := httptest.NewRequest("GET", "/api/chirps", nil)
+			if preflight {
+				request = httptest.NewRequest("OPTIONS", "/api/chirps", nil)
+				request.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			request.Header.Set("Origin", tc.origin)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if got := recorder.Header().Get("Access-Control-Allow-Origin"); got != tc.expectedAllowOrigin {
+				t.Errorf("%s, preflight %t: expected allow origin %q | got %q", tc.origin, preflight, tc.expectedAllowOrigin, got)
+			}
+			if got := recorder.Header().Get("Access-Control-Allow-Credentials"); got != tc.expectedAllowCredentials {
+				t.Errorf("%s, preflight %t: expected allow credentials %q | got %q", tc.origin, preflight, tc.expectedAllowCredentials, got)
+			}
+		}
+	}
+
+	appConfig := DefaultConfig()
+	appConfig.CORSAllowedOrigins = StringList{"*"}
+	appConfig.CORSAllowCredentials = true
+	err := appConfig.Validate()
+	if err == nil || !strings.Contains(err.Error(), "cors_allow_credentials") {
+		t.Errorf("expected Validate to reject \"*\" with credentials | got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrDBKey is returned when the database is encrypted and none of the configured keys is the one it was encrypted with
+var ErrDBKey = errors.New("wrong database encryption key")
+
+const encryptionAlgorithm = "aes-256-gcm"
+
+// encryptedFile is what an encrypted database looks like on disk, the plaintext is the whole regular database file
+//
+//	{"encryption":"aes-256-gcm","key_id":"…","nonce":"<base64>","ciphertext":"<base64>"}
+type encryptedFile struct {
+	Encryption string `json:"encryption"`
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// dbKey is an AES-256 key, its id tells which key a file needs without trying them all
+type dbKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func parseDBKey(encoded string) (*dbKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("must be base64, generate one with `openssl rand -base64 32`")
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("must be 32 bytes for AES-256, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &dbKey{id: hex.EncodeToString(sum[:8]), aead: aead}, nil
+}
+
+// dbKeys encrypts every write with current and decrypts with whichever key the file was written with,
+// so rotating is moving the key to the old keys and setting a new one: the next write re-encrypts the file
+// a nil *dbKeys leaves the database in plaintext
+type dbKeys struct {
+	current *dbKey
+	old     []*dbKey
+	// allowPlaintext accepts a file that isn't encrypted yet although there's a current key
+	allowPlaintext bool
+}
+
+// newDBKeys parses the configured keys, it returns nil when there are none
+// (!) old keys without a current one decrypt the database and the next write stores it in plaintext again
+func newDBKeys(current string, old []string) (*dbKeys, error) {
+	if current == "" && len(old) == 0 {
+		return nil, nil
+	}
+	keys := &dbKeys{}
+	var err error
+	if current != "" {
+		keys.current, err = parseDBKey(current)
+		if err != nil {
+			return nil, fmt.Errorf("db_encryption_key: %w", err)
+		}
+	}
+	for i, encoded := range old {
+		key, err := parseDBKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("db_encryption_old_keys[%d]: %w", i, err)
+		}
+		keys.old = append(keys.old, key)
+	}
+	return keys, nil
+}
+
+// encrypt wraps a database file in an encryptedFile, as is without a current key
+func (keys *dbKeys) encrypt(plaintext []byte) ([]byte, error) {
+	if keys == nil || keys.current == nil {
+		return plaintext, nil
+	}
+	nonce := make([]byte, keys.current.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encryptedFile{
+		Encryption: encryptionAlgorithm,
+		KeyID:      keys.current.id,
+		Nonce:      nonce,
+		Ciphertext: keys.current.aead.Seal(nil, nonce, plaintext, []byte(keys.current.id)),
+	})
+}
+
+// decrypt unwraps an encryptedFile, anything else is returned as is without a current key or with allowPlaintext
+// so turning encryption on works with the plaintext file that's already there
+func (keys *dbKeys) decrypt(byteData []byte) ([]byte, error) {
+	file := encryptedFile{}
+	err := json.Unmarshal(byteData, &file)
+	if err != nil || file.Encryption == "" {
+		// (!) once there's a key a plaintext file is what someone who can write the file but lacks the key would leave
+		if keys != nil && keys.current != nil && !keys.allowPlaintext {
+			return nil, fmt.Errorf("%w: the database isn't encrypted, set db_encryption_allow_plaintext once to encrypt it with db_encryption_key", ErrDBKey)
+		}
+		// not ours to judge, verifyData reports files that aren't JSON
+		return byteData, nil
+	}
+	if file.Encryption != encryptionAlgorithm {
+		return nil, fmt.Errorf("unsupported database encryption %q", file.Encryption)
+	}
+	if keys == nil {
+		return nil, fmt.Errorf("%w: the database is encrypted, set db_encryption_key", ErrDBKey)
+	}
+
+	key := keys.find(file.KeyID)
+	if key == nil {
+		return nil, fmt.Errorf("%w: the database is encrypted with key %s, which is neither db_encryption_key nor one of db_encryption_old_keys", ErrDBKey, file.KeyID)
+	}
+	if len(file.Nonce) != key.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce", ErrDBCorrupt)
+	}
+	plaintext, err := key.aead.Open(nil, file.Nonce, file.Ciphertext, []byte(file.KeyID))
+	if err != nil {
+		// (!) the key id matched, so it's the data that's wrong and not the key
+		return nil, fmt.Errorf("%w: the encrypted data doesn't authenticate with key %s", ErrDBCorrupt, file.KeyID)
+	}
+	return plaintext, nil
+}
+
+func (keys *dbKeys) find(id string) *dbKey {
+	if keys.current != nil && keys.current.id == id {
+		return keys.current
+	}
+	for _, key := range keys.old {
+		if key.id == id {
+			return key
+		}
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func mustDBKeys(t *testing.T, current string, old ...string) *dbKeys {
+	t.Helper()
+	keys, err := newDBKeys(current, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// keyIDOnDisk is the key the database file is encrypted with, "" for plaintext
+func keyIDOnDisk(t *testing.T, path string) string {
+	t.Helper()
+	byteData, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := encryptedFile{}
+	err = json.Unmarshal(byteData, &file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file.KeyID
+}
+
+func TestNewDBKeys(t *testing.T) {
+	t.Parallel()
+
+	keys, err := newDBKeys("", nil)
+	if keys != nil || err != nil {
+		t.Errorf("expected no keys and no error without a key | got %v, %v", keys, err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		current string
+		old     []string
+		errText string
+	}{
+		{name: "not base64", current: "not a key!", errText: "db_encryption_key: must be base64"},
+		{name: "too short", current: base64.StdEncoding.EncodeToString([]byte("short")), errText: "must be 32 bytes"},
+		{name: "bad old key", current: testKey(1), old: []string{testKey(2), "nope"}, errText: "db_encryption_old_keys[1]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newDBKeys(tc.current, tc.old)
+			if err == nil || !strings.Contains(err.Error(), tc.errText) {
+				t.Errorf("expected an error containing %q | got %v", tc.errText, err)
+			}
+		})
+	}
+
+	appConfig := DefaultConfig()
+	appConfig.DBEncryptionKey = "not a key!"
+	err = appConfig.Validate()
+	if err == nil || !strings.Contains(err.Error(), "db_encryption_key") {
+		t.Errorf("expected Validate to reject the key | got %v", err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Parallel()
+
+	keys := mustDBKeys(t, testKey(1))
+	plaintext := []byte(`{"schema_version":2,"chirps":{}}`)
+
+	encrypted, err := keys.encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(encrypted, []byte("chirps")) {
+		t.Errorf("expected the plaintext to be hidden | got %s", encrypted)
+	}
+	decrypted, err := keys.decrypt(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("expected %s | got %s", plaintext, decrypted)
+	}
+
+	// (!) plaintext is refused once there's a key, unless it's explicitly allowed to encrypt an existing database
+	_, err = keys.decrypt(plaintext)
+	if !errors.Is(err, ErrDBKey) {
+		t.Errorf("expected %v for plaintext | got %v", ErrDBKey, err)
+	}
+	allowPlaintext := mustDBKeys(t, testKey(1))
+	allowPlaintext.allowPlaintext = true
+	decrypted, err = allowPlaintext.decrypt(plaintext)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("expected %s | got %s, %v", plaintext, decrypted, err)
+	}
+	// old keys without a current one are how encryption is turned off again
+	decrypted, err = (&dbKeys{old: keys.old}).decrypt(plaintext)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("expected %s | got %s, %v", plaintext, decrypted, err)
+	}
+
+	var noKeys *dbKeys
+	_, err = noKeys.decrypt(encrypted)
+	if !errors.Is(err, ErrDBKey) {
+		t.Errorf("expected %v without a key | got %v", ErrDBKey, err)
+	}
+	_, err = mustDBKeys(t, testKey(2)).decrypt(encrypted)
+	if !errors.Is(err, ErrDBKey) {
+		t.Errorf("expected %v with the wrong key | got %v", ErrDBKey, err)
+	}
+
+	file := encryptedFile{}
+	err = json.Unmarshal(encrypted, &file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Ciphertext[0] ^= 0xff
+	tampered, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = keys.decrypt(tampered)
+	if !errors.Is(err, ErrDBCorrupt) {
+		t.Errorf("expected %v for tampered data | got %v", ErrDBCorrupt, err)
+	}
+}
+
+func TestDBEncryptionKeyRotation(t *testing.T) {
+	t.Parallel()
+
+	dbPath := filepath.Join(t.TempDir(), "database.json")
+	keyA := mustDBKeys(t, testKey(1))
+	keyB := mustDBKeys(t, testKey(2))
+
+	db, err := NewDB(dbPath, WithEncryption(keyA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateChirp("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if keyIDOnDisk(t, dbPath) != keyA.current.id {
+		t.Fatalf("expected the database to be encrypted with key %s | got %q", keyA.current.id, keyIDOnDisk(t, dbPath))
+	}
+
+	_, err = NewDB(dbPath, WithEncryption(keyB))
+	if !errors.Is(err, ErrDBKey) {
+		t.Fatalf("expected %v with only the new key | got %v", ErrDBKey, err)
+	}
+
+	db, err = NewDB(dbPath, WithEncryption(mustDBKeys(t, testKey(2), testKey(1))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	chirps, err := db.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyIDOnDisk(t, dbPath) != keyA.current.id {
+		t.Errorf("expected reads to leave the file encrypted with the old key")
+	}
+	_, err = db.CreateChirp("rotated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyIDOnDisk(t, dbPath) != keyB.current.id {
+		t.Errorf("expected the write to re-encrypt with key %s | got %q", keyB.current.id, keyIDOnDisk(t, dbPath))
+	}
+
+	db, err = NewDB(dbPath, WithEncryption(keyB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	rotated, err := db.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append(chirps, Chirp{ID: 2, Body: "rotated"})
+	if !reflect.DeepEqual(rotated, expected) {
+		t.Errorf("expected %v | got %v", expected, rotated)
+	}
+}
+
+func TestDBEncryptionPlaintext(t *testing.T) {
+	t.Parallel()
+
+	dbPath := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateChirp("plaintext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	appConfig := DefaultConfig()
+	appConfig.DBEncryptionKey = testKey(1)
+	keys, err := appConfig.dbKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewDB(dbPath, WithEncryption(keys))
+	if !errors.Is(err, ErrDBKey) {
+		t.Fatalf("expected %v for a plaintext database | got %v", ErrDBKey, err)
+	}
+
+	appConfig.DBEncryptionAllowPlaintext = true
+	keys, err = appConfig.dbKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = NewDB(dbPath, WithEncryption(keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateChirp("encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if keyIDOnDisk(t, dbPath) != keys.current.id {
+		t.Errorf("expected the write to encrypt the database with key %s | got %q", keys.current.id, keyIDOnDisk(t, dbPath))
+	}
+
+	// a new database never exists in plaintext
+	newPath := filepath.Join(t.TempDir(), "database.json")
+	db, err = NewDB(newPath, WithEncryption(mustDBKeys(t, testKey(1))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if keyIDOnDisk(t, newPath) != keys.current.id {
+		t.Errorf("expected a new database to be encrypted with key %s | got %q", keys.current.id, keyIDOnDisk(t, newPath))
+	}
+}
+
+func TestEncryptedSnapshot(t *testing.T) {
+	t.Parallel()
+
+	dbPath := filepath.Join(t.TempDir(), "database.json")
+	keys := mustDBKeys(t, testKey(1))
+	db, err := NewDB(dbPath, WithEncryption(keys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.CreateChirp("backed up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotPath := filepath.Join(t.TempDir(), "snapshot.json")
+	buf := &bytes.Buffer{}
+	err = db.Snapshot(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(snapshotPath, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readSnapshot(snapshotPath, nil)
+	if !errors.Is(err, ErrDBKey) {
+		t.Errorf("expected %v without a key | got %v", ErrDBKey, err)
+	}
+	dbStructure, err := readSnapshot(snapshotPath, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[int]Chirp{1: {ID: 1, Body: "backed up"}}
+	if !reflect.DeepEqual(dbStructure.Chirps, expected) {
+		t.Errorf("expected %v | got %v", expected, dbStructure.Chirps)
+	}
+}
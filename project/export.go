@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// export formats, every record names the collection it belongs to
+// so more collections can be added without breaking old files
+//
+//	ndjson: {"collection":"chirps","id":1,"body":"hello"}
+//	csv:    collection,id,body
+const (
+	exportFormatNDJSON = "ndjson"
+	exportFormatCSV    = "csv"
+)
+
+const collectionChirps = "chirps"
+
+var csvHeader = []string{"collection", "id", "body"}
+
+// exportRecord is a single line of an export
+type exportRecord struct {
+	Collection string `json:"collection"`
+	ID         int    `json:"id"`
+	Body       string `json:"body"`
+}
+
+func exportContentType(format string) (string, error) {
+	switch format {
+	case exportFormatNDJSON:
+		return "application/x-ndjson", nil
+	case exportFormatCSV:
+		return "text/csv; charset=utf-8", nil
+	}
+	return "", fmt.Errorf("unknown export format %q, use %s or %s", format, exportFormatNDJSON, exportFormatCSV)
+}
+
+// exportChirps writes every chirp to w in format
+func exportChirps(w io.Writer, format string, chirps []Chirp) error {
+	switch format {
+	case exportFormatNDJSON:
+		encoder := json.NewEncoder(w)
+		for _, chirp := range chirps {
+			err := encoder.Encode(exportRecord{Collection: collectionChirps, ID: chirp.ID, Body: chirp.Body})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case exportFormatCSV:
+		writer := csv.NewWriter(w)
+		err := writer.Write(csvHeader)
+		if err != nil {
+			return err
+		}
+		for _, chirp := range chirps {
+			err := writer.Write([]string{collectionChirps, strconv.Itoa(chirp.ID), chirp.Body})
+			if err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	_, err := exportContentType(format)
+	return err
+}
+
+// importLineError is a record that can't be imported, Line starts at 1
+type importLineError struct {
+	Line int
+	Err  error
+}
+
+func (ile importLineError) Error() string {
+	return fmt.Sprintf("line %d: %s", ile.Line, ile.Err)
+}
+
+// parseImport reads the chirps of an export in format
+// every record goes through validateChirp like a posted chirp, the errors of all bad lines are returned together
+// (!) ids are not kept, imported chirps are numbered after the ones already in the database
+func parseImport(r io.Reader, format string) ([]Chirp, error) {
+	var records []exportRecord
+	var errs []error
+
+	switch format {
+	case exportFormatNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+			record := exportRecord{}
+			decoder := json.NewDecoder(strings.NewReader(text))
+			decoder.DisallowUnknownFields()
+			err := decoder.Decode(&record)
+			if err != nil {
+				errs = append(errs, importLineError{Line: line, Err: err})
+				continue
+			}
+			errs = appendRecord(&records, record, line, errs)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+
+	case exportFormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = len(csvHeader)
+		header, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.Join(header, ",") != strings.Join(csvHeader, ",") {
+			return nil, importLineError{Line: 1, Err: fmt.Errorf("expected the header %s", strings.Join(csvHeader, ","))}
+		}
+		for {
+			fields, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			line, _ := reader.FieldPos(0)
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				errs = append(errs, importLineError{Line: parseErr.StartLine, Err: parseErr.Err})
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			id, err := strconv.Atoi(fields[1])
+			if err != nil {
+				errs = append(errs, importLineError{Line: line, Err: fmt.Errorf("invalid id %q", fields[1])})
+				continue
+			}
+			errs = appendRecord(&records, exportRecord{Collection: fields[0], ID: id, Body: fields[2]}, line, errs)
+		}
+
+	default:
+		_, err := exportContentType(format)
+		return nil, err
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	chirps := make([]Chirp, 0, len(records))
+	for _, record := range records {
+		chirps = append(chirps, Chirp{ID: record.ID, Body: record.Body})
+	}
+	return chirps, nil
+}
+
+// appendRecord validates record and adds it to records, or adds its error to errs
+func appendRecord(records *[]exportRecord, record exportRecord, line int, errs []error) []error {
+	if record.Collection != collectionChirps {
+		return append(errs, importLineError{Line: line, Err: fmt.Errorf("unknown collection %q", record.Collection)})
+	}
+	err := validateChirp(record.Body)
+	if err != nil {
+		return append(errs, importLineError{Line: line, Err: err})
+	}
+	*records = append(*records, record)
+	return errs
+}
+
+// handlerExport streams every collection as NDJSON (the default) or CSV, picked with ?format=
+func (cfg *apiConfig) handlerExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatNDJSON
+	}
+	contentType, err := exportContentType(format)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	chirps, err := cfg.db.GetChirps()
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read the database")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+	err = exportChirps(w, format, chirps)
+	if err != nil {
+		// (!) the status is already out, usually this is the client going away mid download
+		log.Printf("error writing export: %s", err)
+	}
+}
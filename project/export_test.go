@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	chirps := []Chirp{
+		{ID: 1, Body: "hello"},
+		{ID: 2, Body: `with "quotes", commas` + "\nand a newline"},
+	}
+
+	for _, format := range []string{exportFormatNDJSON, exportFormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			var buffer bytes.Buffer
+			err := exportChirps(&buffer, format, chirps)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			imported, err := parseImport(&buffer, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(imported, chirps) {
+				t.Errorf("expected %v | got %v", chirps, imported)
+			}
+		})
+	}
+}
+
+func TestParseImportErrors(t *testing.T) {
+	testCases := []struct {
+		name          string
+		format        string
+		input         string
+		expectedLines []int
+	}{
+		{
+			name:   "ndjson",
+			format: exportFormatNDJSON,
+			input: `{"collection":"chirps","id":1,"body":"fine"}
+{"collection":"chirps","id":2,"body":"` + strings.Repeat("a", chirpMaxLength+1) + `"}
+
+{"collection":"users","id":3,"body":"nope"}
+not json
+{"collection":"chirps","id":4,"body":"fine","extra":true}
+`,
+			expectedLines: []int{2, 4, 5, 6},
+		},
+		{
+			name:   "csv",
+			format: exportFormatCSV,
+			input: `collection,id,body
+chirps,1,fine
+chirps,two,bad id
+chirps,3,` + strings.Repeat("a", chirpMaxLength+1) + `
+chirps,4
+`,
+			expectedLines: []int{3, 4, 5},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseImport(strings.NewReader(tc.input), tc.format)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			var lines []int
+			for _, lineErr := range err.(interface{ Unwrap() []error }).Unwrap() {
+				var ile importLineError
+				if !errors.As(lineErr, &ile) {
+					t.Fatalf("expected an importLineError | got %v", lineErr)
+				}
+				lines = append(lines, ile.Line)
+			}
+			if !reflect.DeepEqual(lines, tc.expectedLines) {
+				t.Errorf("expected errors on lines %v | got %v: %v", tc.expectedLines, lines, err)
+			}
+		})
+	}
+}
+
+func TestHandlerExport(t *testing.T) {
+	t.Parallel()
+
+	appConfig := newTestConfig(t)
+	testServer := newTestServer(t, appConfig)
+	client := testServer.Client()
+
+	response, err := client.Post(testServer.URL+"/api/chirps", "application/json", strings.NewReader(`{"body":"exported"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	testCases := []struct {
+		query               string
+		expectedStatus      int
+		expectedContentType string
+		expectedBody        string
+	}{
+		{query: "", expectedStatus: http.StatusOK, expectedContentType: "application/x-ndjson", expectedBody: `{"collection":"chirps","id":1,"body":"exported"}` + "\n"},
+		{query: "?format=csv", expectedStatus: http.StatusOK, expectedContentType: "text/csv; charset=utf-8", expectedBody: "collection,id,body\nchirps,1,exported\n"},
+		{query: "?format=xml", expectedStatus: http.StatusBadRequest, expectedContentType: "application/json"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			response := adminRequest(t, client, "GET", testServer.URL+"/api/admin/export"+tc.query)
+			defer response.Body.Close()
+
+			if response.StatusCode != tc.expectedStatus {
+				t.Errorf("expected status code %d | got %d", tc.expectedStatus, response.StatusCode)
+			}
+			if response.Header.Get("Content-Type") != tc.expectedContentType {
+				t.Errorf("expected Content-Type %s | got %s", tc.expectedContentType, response.Header.Get("Content-Type"))
+			}
+			if tc.expectedBody == "" {
+				return
+			}
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(body) != tc.expectedBody {
+				t.Errorf("expected %q | got %q", tc.expectedBody, body)
+			}
+		})
+	}
+}
+
+func TestHandlerExportDefaultConfig(t *testing.T) {
+	t.Parallel()
+
+	// (!) DefaultConfig has no admin token, the export would hand out the whole database
+	appConfig := newTestConfig(t)
+	appConfig.AdminToken = ""
+	testServer := newTestServer(t, appConfig)
+	client := testServer.Client()
+
+	response, err := client.Post(testServer.URL+"/api/chirps", "application/json", strings.NewReader(`{"body":"private"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	response, err = client.Get(testServer.URL + "/api/admin/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusForbidden {
+		t.Errorf("expected status code %d | got %d", http.StatusForbidden, response.StatusCode)
+	}
+	if strings.Contains(string(body), "private") {
+		t.Errorf("expected no chirps in the response | got %s", body)
+	}
+}
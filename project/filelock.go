@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrDBLocked is returned when another process holds the database lock for longer than the lock timeout
+var ErrDBLocked = errors.New("database is locked by another process")
+
+// defaultLockTimeout is how long NewDB waits for the lock unless WithLockTimeout says otherwise
+const defaultLockTimeout = 5 * time.Second
+
+// errWouldBlock is what tryLock returns when someone else holds a conflicting lock
+var errWouldBlock = errors.New("lock is held")
+
+// fileLock is an advisory lock shared with every process that opens the same lock file,
+// readers take it shared and writers exclusive
+// (!) it locks <db-path>.lock and not the database itself: writeDB renames a new file over the database,
+// and a lock on the old file would stop protecting anything after the first write
+type fileLock struct {
+	file *os.File
+
+	// (!) flock locks belong to the open file, not to a goroutine: the first reader takes the shared lock
+	// and the last one releases it, otherwise one reader finishing would unlock the others
+	mux     sync.Mutex
+	readers int
+}
+
+func lockPath(dbPath string) string {
+	return dbPath + ".lock"
+}
+
+func openFileLock(path string) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileLock{file: file}, nil
+}
+
+// Lock takes the exclusive lock, the caller must already exclude every other goroutine using fl
+func (fl *fileLock) Lock(timeout time.Duration) error {
+	return fl.wait(true, timeout)
+}
+
+func (fl *fileLock) Unlock() error {
+	return fl.unlock()
+}
+
+// RLock takes the shared lock, or joins the readers already holding it
+func (fl *fileLock) RLock(timeout time.Duration) error {
+	fl.mux.Lock()
+	defer fl.mux.Unlock()
+
+	if fl.readers == 0 {
+		err := fl.wait(false, timeout)
+		if err != nil {
+			return err
+		}
+	}
+	fl.readers++
+	return nil
+}
+
+func (fl *fileLock) RUnlock() error {
+	fl.mux.Lock()
+	defer fl.mux.Unlock()
+
+	fl.readers--
+	if fl.readers > 0 {
+		return nil
+	}
+	return fl.unlock()
+}
+
+// wait polls for the lock until timeout because flock itself can't time out
+func (fl *fileLock) wait(exclusive bool, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	wait := time.Millisecond
+	for {
+		err := fl.tryLock(exclusive)
+		if !errors.Is(err, errWouldBlock) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: %s is still locked after %s", ErrDBLocked, fl.file.Name(), timeout)
+		}
+		time.Sleep(wait)
+		wait = min(2*wait, 50*time.Millisecond)
+	}
+}
+
+func (fl *fileLock) Close() error {
+	// closing the file also releases a lock we might still hold
+	return fl.file.Close()
+}
+
+// withFileLock runs fn holding the lock of the database at dbPath,
+// for the commands that work on the file without going through a DB
+func withFileLock(dbPath string, exclusive bool, timeout time.Duration, fn func() error) error {
+	fl, err := openFileLock(lockPath(dbPath))
+	if err != nil {
+		return err
+	}
+	defer fl.Close()
+
+	if exclusive {
+		err = fl.Lock(timeout)
+		if err != nil {
+			return err
+		}
+		defer fl.Unlock()
+		return fn()
+	}
+	err = fl.RLock(timeout)
+	if err != nil {
+		return err
+	}
+	defer fl.RUnlock()
+	return fn()
+}
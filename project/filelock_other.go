@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+// (!) there's no flock here, the database is only protected within a single process
+
+func (fl *fileLock) tryLock(exclusive bool) error {
+	return nil
+}
+
+func (fl *fileLock) unlock() error {
+	return nil
+}
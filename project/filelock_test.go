@@ -0,0 +1,179 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// holdLockEnv tells the re-executed test binary which lock file TestHelperHoldLock should hold
+const holdLockEnv = "CHIRPY_TEST_HOLD_LOCK"
+
+// TestHelperHoldLock isn't a test: TestDBLockedByAnotherRealProcess runs the test binary again with holdLockEnv set,
+// it takes the exclusive lock, says "locked" and keeps it until its stdin is closed
+func TestHelperHoldLock(t *testing.T) {
+	path := os.Getenv(holdLockEnv)
+	if path == "" {
+		t.Skip("only run as a helper process")
+	}
+
+	fl, err := openFileLock(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fl.Lock(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout.WriteString("locked\n")
+	io.Copy(io.Discard, os.Stdin)
+	// (!) no Unlock, the lock must go away with the process
+	os.Exit(0)
+}
+
+func TestDBLockedByAnotherRealProcess(t *testing.T) {
+	t.Parallel()
+
+	dbPath := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(dbPath, WithLockTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	helper := exec.Command(os.Args[0], "-test.run=^TestHelperHoldLock$")
+	helper.Env = append(os.Environ(), holdLockEnv+"="+lockPath(dbPath))
+	helper.Stderr = os.Stderr
+	stdin, err := helper.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := helper.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = helper.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer helper.Process.Kill()
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "locked\n" {
+		t.Fatalf("expected the helper process to take the lock | got %q, %v", line, err)
+	}
+
+	_, err = db.CreateChirp("blocked")
+	if !errors.Is(err, ErrDBLocked) {
+		t.Errorf("expected %v | got %v", ErrDBLocked, err)
+	}
+
+	stdin.Close()
+	err = helper.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateChirp("unblocked")
+	if err != nil {
+		t.Fatalf("expected the write to go through once the process holding the lock exited | got %v", err)
+	}
+}
+
+func TestDBLockedByAnotherProcess(t *testing.T) {
+	t.Parallel()
+
+	dbPath := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(dbPath, WithLockTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// (!) a second open file description conflicts like another process would
+	other, err := openFileLock(lockPath(dbPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	err = other.Lock(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateChirp("blocked")
+	if !errors.Is(err, ErrDBLocked) {
+		t.Errorf("expected %v | got %v", ErrDBLocked, err)
+	}
+	_, err = db.GetChirps()
+	if !errors.Is(err, ErrDBLocked) {
+		t.Errorf("expected %v | got %v", ErrDBLocked, err)
+	}
+	other.Unlock()
+
+	_, err = db.CreateChirp("unblocked")
+	if err != nil {
+		t.Fatalf("expected the write to go through once the lock is released | got %v", err)
+	}
+}
+
+func TestDBSharedLock(t *testing.T) {
+	t.Parallel()
+
+	dbPath := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(dbPath, WithLockTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	other, err := openFileLock(lockPath(dbPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	err = other.RLock(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.RUnlock()
+
+	_, err = db.GetChirps()
+	if err != nil {
+		t.Errorf("expected readers not to block each other | got %v", err)
+	}
+	_, err = db.CreateChirp("blocked by a reader")
+	if !errors.Is(err, ErrDBLocked) {
+		t.Errorf("expected %v | got %v", ErrDBLocked, err)
+	}
+}
+
+func TestWithFileLock(t *testing.T) {
+	t.Parallel()
+
+	dbPath := filepath.Join(t.TempDir(), "database.json")
+	err := withFileLock(dbPath, true, time.Second, func() error {
+		return withFileLock(dbPath, false, 20*time.Millisecond, func() error {
+			return nil
+		})
+	})
+	if !errors.Is(err, ErrDBLocked) {
+		t.Errorf("expected %v | got %v", ErrDBLocked, err)
+	}
+
+	err = withFileLock(dbPath, false, time.Second, func() error {
+		return withFileLock(dbPath, false, 20*time.Millisecond, func() error {
+			return nil
+		})
+	})
+	if err != nil {
+		t.Errorf("expected shared locks to stack | got %v", err)
+	}
+}
@@ -1,3 +1,3 @@
 module go-web-servers
 
-go 1.22.5
+go 1.24.0
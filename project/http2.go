@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+)
+
+// configureHTTP2 sets which protocols server speaks and tunes HTTP/2
+// HTTP/2 over TLS is always on when TLS is, h2c (HTTP/2 over cleartext) is opt-in
+// because only clients that know about it up front (e.g. our service mesh) can use it
+func configureHTTP2(server *http.Server, appConfig Config) {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	// (!) h2c uses "prior knowledge", the client starts talking HTTP/2 straight away
+	// and HTTP/1.1 requests on the same listener keep working
+	protocols.SetUnencryptedHTTP2(appConfig.H2C)
+	server.Protocols = protocols
+
+	server.HTTP2 = &http.HTTP2Config{
+		MaxConcurrentStreams: appConfig.HTTP2MaxConcurrentStreams,
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newH2CClient only speaks HTTP/2 over cleartext, like the clients in our service mesh
+func newH2CClient() *http.Client {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Client{
+		Transport: &http.Transport{Protocols: protocols},
+	}
+}
+
+func newHTTP2TestServer(t *testing.T, h2c bool) *httptest.Server {
+	t.Helper()
+
+	appConfig := DefaultConfig()
+	appConfig.H2C = h2c
+	appConfig.HTTP2MaxConcurrentStreams = 10
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(handlerReadiness))
+	configureHTTP2(server.Config, appConfig)
+	server.Start()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestH2C(t *testing.T) {
+	server := newHTTP2TestServer(t, true)
+
+	response, err := newH2CClient().Get(server.URL + "/api/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.ProtoMajor != 2 {
+		t.Errorf("expected protocol %s | got %s", "HTTP/2.0", response.Proto)
+	}
+
+	// HTTP/1.1 clients keep working on the same listener
+	response, err = http.Get(server.URL + "/api/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.ProtoMajor != 1 {
+		t.Errorf("expected protocol %s | got %s", "HTTP/1.1", response.Proto)
+	}
+}
+
+func TestH2CDisabled(t *testing.T) {
+	server := newHTTP2TestServer(t, false)
+
+	response, err := newH2CClient().Get(server.URL + "/api/healthz")
+	if err == nil {
+		response.Body.Close()
+		t.Errorf("expected h2c to be refused | got %s", response.Proto)
+	}
+}
+
+func TestHTTP2MaxConcurrentStreams(t *testing.T) {
+	server := newHTTP2TestServer(t, true)
+	if server.Config.HTTP2.MaxConcurrentStreams != 10 {
+		t.Errorf("expected %d concurrent streams configured | got %d", 10, server.Config.HTTP2.MaxConcurrentStreams)
+	}
+
+	// (!) check what the server announces, the setting could be configured and still not reach the connection
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.WriteString(conn, "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the server's first frame is its SETTINGS: a 9 byte header, then 6 bytes per setting
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 9)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const frameSettings, settingMaxConcurrentStreams = 0x4, 0x3
+	if header[3] != frameSettings {
+		t.Fatalf("expected a SETTINGS frame | got frame type %d", header[3])
+	}
+	payload := make([]byte, int(header[0])<<16|int(header[1])<<8|int(header[2]))
+	_, err = io.ReadFull(reader, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+6 <= len(payload); i += 6 {
+		if binary.BigEndian.Uint16(payload[i:]) == settingMaxConcurrentStreams {
+			value := binary.BigEndian.Uint32(payload[i+2:])
+			if value != 10 {
+				t.Errorf("expected SETTINGS_MAX_CONCURRENT_STREAMS %d | got %d", 10, value)
+			}
+			return
+		}
+	}
+	t.Errorf("expected SETTINGS_MAX_CONCURRENT_STREAMS in %x", payload)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrDBCorrupt is returned when the database file fails its integrity checks
+var ErrDBCorrupt = errors.New("database is corrupt")
+
+// checksumSchemaVersion is the first schema version that stores a checksum
+const checksumSchemaVersion = 2
+
+// checksum is the sha256 of every top level key except "checksum" itself, in compact JSON with sorted keys
+func checksum(db map[string]json.RawMessage) (string, error) {
+	data := make(map[string]json.RawMessage, len(db))
+	for key, value := range db {
+		if key != "checksum" {
+			data[key] = value
+		}
+	}
+	// (!) Marshal sorts the keys and compacts every json.RawMessage, so the bytes don't depend on formatting
+	byteData, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(byteData)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// sealData stores the checksum of the database in byteData
+func sealData(byteData []byte) ([]byte, error) {
+	db := map[string]json.RawMessage{}
+	err := json.Unmarshal(byteData, &db)
+	if err != nil {
+		return nil, err
+	}
+	err = seal(db)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(db)
+}
+
+func seal(db map[string]json.RawMessage) error {
+	sum, err := checksum(db)
+	if err != nil {
+		return err
+	}
+	db["checksum"], err = json.Marshal(sum)
+	return err
+}
+
+// verifyData checks byteData against the checksum it carries
+func verifyData(byteData []byte) error {
+	db := map[string]json.RawMessage{}
+	err := json.Unmarshal(byteData, &db)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDBCorrupt, err)
+	}
+	return verify(db)
+}
+
+func verify(db map[string]json.RawMessage) error {
+	version, err := schemaVersion(db)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDBCorrupt, err)
+	}
+	if version < checksumSchemaVersion {
+		return nil
+	}
+
+	stored := ""
+	err = json.Unmarshal(db["checksum"], &stored)
+	if err != nil || stored == "" {
+		return fmt.Errorf("%w: the checksum is missing", ErrDBCorrupt)
+	}
+	sum, err := checksum(db)
+	if err != nil {
+		return err
+	}
+	if stored != sum {
+		return fmt.Errorf("%w: checksum mismatch, stored %s, computed %s", ErrDBCorrupt, stored, sum)
+	}
+	return nil
+}
+
+// fsckIssue is an inconsistency fsck found, only repairable issues are fixed by -repair
+type fsckIssue struct {
+	Description string
+	Repairable  bool
+}
+
+// fsckData checks a database file and returns its issues along with a repaired copy of the data
+// the repair trusts the map keys: they are unique, and they're what ID lookups go through
+func fsckData(byteData []byte) ([]fsckIssue, DBStructure, error) {
+	// (!) check the checksum of the file as it is on disk, migrating reseals it
+	var issues []fsckIssue
+	err := verifyData(byteData)
+	if err != nil {
+		issues = append(issues, fsckIssue{Description: err.Error(), Repairable: true})
+	}
+
+	// an outdated schema is upgraded in memory
+	migrated, _, err := migrateData(byteData)
+	if err != nil {
+		return nil, DBStructure{}, fmt.Errorf("%w: %w", ErrDBCorrupt, err)
+	}
+
+	dbStructure := DBStructure{}
+	err = json.Unmarshal(migrated, &dbStructure)
+	if err != nil {
+		return nil, DBStructure{}, fmt.Errorf("%w: %w", ErrDBCorrupt, err)
+	}
+	if dbStructure.Chirps == nil {
+		dbStructure.Chirps = make(map[int]Chirp)
+	}
+
+	keys := make([]int, 0, len(dbStructure.Chirps))
+	for key := range dbStructure.Chirps {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+
+	keysByID := map[int][]int{}
+	for _, key := range keys {
+		chirp := dbStructure.Chirps[key]
+		keysByID[chirp.ID] = append(keysByID[chirp.ID], key)
+	}
+	for _, key := range keys {
+		chirp := dbStructure.Chirps[key]
+		if len(keysByID[chirp.ID]) > 1 && keysByID[chirp.ID][0] == key {
+			issues = append(issues, fsckIssue{
+				Description: fmt.Sprintf("chirp id %d is used under the keys %v", chirp.ID, keysByID[chirp.ID]),
+				Repairable:  true,
+			})
+		}
+		if chirp.ID != key {
+			issues = append(issues, fsckIssue{
+				Description: fmt.Sprintf("chirp stored under key %d has id %d", key, chirp.ID),
+				Repairable:  true,
+			})
+			chirp.ID = key
+			dbStructure.Chirps[key] = chirp
+		}
+		err := validateChirp(chirp.Body)
+		if err != nil {
+			issues = append(issues, fsckIssue{Description: fmt.Sprintf("chirp %d: %s", key, err)})
+		}
+	}
+	if len(keys) > 0 && dbStructure.NextChirpID <= keys[len(keys)-1] {
+		issues = append(issues, fsckIssue{
+			Description: fmt.Sprintf("next_chirp_id %d is not past the highest chirp id %d", dbStructure.NextChirpID, keys[len(keys)-1]),
+			Repairable:  true,
+		})
+		dbStructure.NextChirpID = keys[len(keys)-1] + 1
+	}
+
+	return issues, dbStructure, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadDBErrorsArePropagated(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateChirp("precious")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// (!) a read that fails must not turn into an empty database that the next write saves
+	err = os.Rename(path, path+".moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetChirps()
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %v | got %v", os.ErrNotExist, err)
+	}
+	_, err = db.CreateChirp("overwrite")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %v | got %v", os.ErrNotExist, err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no new database file | got %v", err)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "database.json")
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.CreateChirp("original")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byteData, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(byteData, []byte("original"), []byte("tampered"), 1)
+	err = os.WriteFile(path, tampered, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.GetChirps()
+	if !errors.Is(err, ErrDBCorrupt) {
+		t.Errorf("expected %v | got %v", ErrDBCorrupt, err)
+	}
+	_, err = db.CreateChirp("more")
+	if !errors.Is(err, ErrDBCorrupt) {
+		t.Errorf("expected %v | got %v", ErrDBCorrupt, err)
+	}
+	afterWrite, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(afterWrite, tampered) {
+		t.Error("expected a corrupt database to be left alone")
+	}
+}
+
+func TestChecksumMismatchBeforeMigrating(t *testing.T) {
+	t.Parallel()
+
+	// (!) a schema version 2 file with a bad checksum must not come out of the migrations resealed
+	tampered, err := os.ReadFile(filepath.Join("testdata", "migrations", "v2.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered = bytes.Replace(tampered, []byte("second breakfast"), []byte("third breakfast"), 1)
+	path := filepath.Join(t.TempDir(), "database.json")
+	err = os.WriteFile(path, tampered, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewDB(path)
+	if !errors.Is(err, ErrDBCorrupt) {
+		t.Errorf("expected %v | got %v", ErrDBCorrupt, err)
+	}
+	issues, _, err := fsckData(tampered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || !issues[0].Repairable {
+		t.Errorf("expected one repairable checksum issue | got %+v", issues)
+	}
+}
+
+func TestFsckData(t *testing.T) {
+	sealed := func(t *testing.T, data string) []byte {
+		t.Helper()
+		byteData, err := sealData([]byte(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return byteData
+	}
+
+	testCases := []struct {
+		name                 string
+		data                 func(t *testing.T) []byte
+		expectedIssues       []string
+		expectedUnrepairable int
+		expectedChirps       map[int]Chirp
+	}{
+		{
+			name: "clean",
+			data: func(t *testing.T) []byte {
+				return sealed(t, `{"schema_version":2,"chirps":{"1":{"id":1,"body":"fine"}}}`)
+			},
+			expectedChirps: map[int]Chirp{1: {ID: 1, Body: "fine"}},
+		},
+		{
+			name: "outdated schema",
+			data: func(t *testing.T) []byte {
+				return []byte(`{"chirps":{"1":{"id":1,"body":"fine"}}}`)
+			},
+			expectedChirps: map[int]Chirp{1: {ID: 1, Body: "fine"}},
+		},
+		{
+			name: "stale checksum",
+			data: func(t *testing.T) []byte {
+				return []byte(`{"schema_version":2,"checksum":"sha256:00","chirps":{"1":{"id":1,"body":"fine"}}}`)
+			},
+			expectedIssues: []string{"checksum mismatch"},
+			expectedChirps: map[int]Chirp{1: {ID: 1, Body: "fine"}},
+		},
+		{
+			name: "duplicate ids and mismatched keys",
+			data: func(t *testing.T) []byte {
+				return sealed(t, `{"schema_version":2,"chirps":{"1":{"id":1,"body":"a"},"2":{"id":1,"body":"b"},"3":{"id":7,"body":"c"}}}`)
+			},
+			expectedIssues: []string{
+				"chirp id 1 is used under the keys [1 2]",
+				"chirp stored under key 2 has id 1",
+				"chirp stored under key 3 has id 7",
+			},
+			expectedChirps: map[int]Chirp{1: {ID: 1, Body: "a"}, 2: {ID: 2, Body: "b"}, 3: {ID: 3, Body: "c"}},
+		},
+		{
+			name: "stale next chirp id",
+			data: func(t *testing.T) []byte {
+				return sealed(t, `{"schema_version":4,"next_chirp_id":2,"chirps":{"1":{"id":1,"body":"a"},"3":{"id":3,"body":"b"}}}`)
+			},
+			expectedIssues: []string{"next_chirp_id 2 is not past the highest chirp id 3"},
+			expectedChirps: map[int]Chirp{1: {ID: 1, Body: "a"}, 3: {ID: 3, Body: "b"}},
+		},
+		{
+			name: "invalid body",
+			data: func(t *testing.T) []byte {
+				return sealed(t, `{"schema_version":2,"chirps":{"1":{"id":1,"body":"`+strings.Repeat("a", chirpMaxLength+1)+`"}}}`)
+			},
+			expectedIssues:       []string{"chirp 1: Chirp is too long"},
+			expectedUnrepairable: 1,
+			expectedChirps:       map[int]Chirp{1: {ID: 1, Body: strings.Repeat("a", chirpMaxLength+1)}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			issues, repaired, err := fsckData(tc.data(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(issues) != len(tc.expectedIssues) {
+				t.Fatalf("expected issues %v | got %v", tc.expectedIssues, issues)
+			}
+			unrepairable := 0
+			for i, issue := range issues {
+				if !strings.Contains(issue.Description, tc.expectedIssues[i]) {
+					t.Errorf("expected issue %q | got %q", tc.expectedIssues[i], issue.Description)
+				}
+				if !issue.Repairable {
+					unrepairable++
+				}
+			}
+			if unrepairable != tc.expectedUnrepairable {
+				t.Errorf("expected %d unrepairable issues | got %d", tc.expectedUnrepairable, unrepairable)
+			}
+			if !reflect.DeepEqual(repaired.Chirps, tc.expectedChirps) {
+				t.Errorf("expected %v | got %v", tc.expectedChirps, repaired.Chirps)
+			}
+			for id := range repaired.Chirps {
+				if repaired.NextChirpID <= id {
+					t.Errorf("expected next_chirp_id past chirp %d | got %d", id, repaired.NextChirpID)
+				}
+			}
+		})
+	}
+}
+
+func TestFsckDataNotJSON(t *testing.T) {
+	_, _, err := fsckData([]byte(`{"chirps":`))
+	if !errors.Is(err, ErrDBCorrupt) {
+		t.Errorf("expected %v | got %v", ErrDBCorrupt, err)
+	}
+}
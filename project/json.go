@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// respondWithJSON marshals payload and writes it with the given status code
+func respondWithJSON(w http.ResponseWriter, code int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	respondWithJSONBody(w, code, payload)
+}
+
+// respondWithJSONBody is respondWithJSON without setting the Content-Type
+func respondWithJSONBody(w http.ResponseWriter, code int, payload any) {
+	byteData, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("error marshalling JSON: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(code)
+	w.Write(byteData)
+}
+
+// respondWithError writes a `{"error": message}` body with the given status code
+func respondWithError(w http.ResponseWriter, code int, message string) {
+	respondWithJSON(w, code, Response{
+		Error: message,
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const headerRequestID = "X-Request-ID"
+
+// maxRequestIDLength caps how much of a client supplied X-Request-ID we trust
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// newLogger builds a structured logger that writes "json" or "text" records to w
+func newLogger(w io.Writer, format string) (*slog.Logger, error) {
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, nil)), nil
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, nil)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want \"json\" or \"text\")", format)
+	}
+}
+
+// middlewareLog writes one access log record per request
+// and makes sure every request carries an X-Request-ID
+func middlewareLog(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			// (!) propagate the caller's request ID so a request can be traced across services
+			requestID := r.Header.Get(headerRequestID)
+			if !validRequestID(requestID) {
+				requestID = newRequestID()
+			}
+			w.Header().Set(headerRequestID, requestID)
+			r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, requestID))
+
+			lw := &loggingResponseWriter{ResponseWriter: w}
+			next.ServeHTTP(lw, r)
+
+			logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+				slog.String("request_id", requestID),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("proto", r.Proto),
+				slog.Int("status", lw.Status()),
+				slog.Int64("bytes", lw.bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("user_agent", r.UserAgent()),
+			)
+		})
+	}
+}
+
+// requestIDFromContext returns the request ID set by middlewareLog, if any
+func requestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// newRequestID returns a random 128 bit hex encoded ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	// (!) crypto/rand.Read never returns an error on supported platforms
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID only accepts short, printable IDs so a client can't inject into our logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// loggingResponseWriter records the status code and number of bytes written
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (lw *loggingResponseWriter) WriteHeader(status int) {
+	if lw.status == 0 {
+		lw.status = status
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	n, err := lw.ResponseWriter.Write(b)
+	lw.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the status code sent to the client, a handler that never writes sends a 200
+func (lw *loggingResponseWriter) Status() int {
+	if lw.status == 0 {
+		return http.StatusOK
+	}
+	return lw.status
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareLog(t *testing.T) {
+	testCases := []struct {
+		name              string
+		incomingRequestID string
+		propagated        bool
+	}{
+		{name: "generated request id", incomingRequestID: "", propagated: false},
+		{name: "propagated request id", incomingRequestID: "abc-123", propagated: true},
+		{name: "rejected request id", incomingRequestID: "bad id\n", propagated: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var logOutput bytes.Buffer
+			logger, err := newLogger(&logOutput, "json")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var contextRequestID string
+			handler := middlewareLog(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contextRequestID = requestIDFromContext(r.Context())
+				w.WriteHeader(http.StatusTeapot)
+				w.Write([]byte("short and stout"))
+			}))
+
+			request := httptest.NewRequest("GET", "/api/healthz", nil)
+			request.Header.Set("User-Agent", "chirpy-test")
+			if tc.incomingRequestID != "" {
+				request.Header.Set(headerRequestID, tc.incomingRequestID)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			responseRequestID := recorder.Header().Get(headerRequestID)
+			if responseRequestID == "" {
+				t.Fatal("expected an X-Request-ID response header")
+			}
+			if tc.propagated && responseRequestID != tc.incomingRequestID {
+				t.Errorf("expected request id %s | got %s", tc.incomingRequestID, responseRequestID)
+			}
+			if !tc.propagated && responseRequestID == tc.incomingRequestID {
+				t.Errorf("expected a generated request id | got %s", responseRequestID)
+			}
+			if contextRequestID != responseRequestID {
+				t.Errorf("expected context request id %s | got %s", responseRequestID, contextRequestID)
+			}
+
+			var record struct {
+				RequestID string `json:"request_id"`
+				Method    string `json:"method"`
+				Path      string `json:"path"`
+				Status    int    `json:"status"`
+				Bytes     int    `json:"bytes"`
+				UserAgent string `json:"user_agent"`
+			}
+			err = json.Unmarshal(logOutput.Bytes(), &record)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if record.RequestID != responseRequestID {
+				t.Errorf("expected logged request id %s | got %s", responseRequestID, record.RequestID)
+			}
+			if record.Method != "GET" || record.Path != "/api/healthz" {
+				t.Errorf("expected GET /api/healthz | got %s %s", record.Method, record.Path)
+			}
+			if record.Status != http.StatusTeapot {
+				t.Errorf("expected status %d | got %d", http.StatusTeapot, record.Status)
+			}
+			if record.Bytes != len("short and stout") {
+				t.Errorf("expected bytes %d | got %d", len("short and stout"), record.Bytes)
+			}
+			if record.UserAgent != "chirpy-test" {
+				t.Errorf("expected user agent %s | got %s", "chirpy-test", record.UserAgent)
+			}
+		})
+	}
+}
+
+func TestNewLoggerFormats(t *testing.T) {
+	testCases := []struct {
+		format   string
+		expected string
+	}{
+		{format: "json", expected: `"msg":"hello","chirps":3}`},
+		{format: "text", expected: `msg=hello chirps=3`},
+		{format: "", expected: `msg=hello chirps=3`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			var logOutput bytes.Buffer
+			logger, err := newLogger(&logOutput, tc.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			logger.Info("hello", "chirps", 3)
+			if !strings.Contains(logOutput.String(), tc.expected) {
+				t.Errorf("expected %s | got %s", tc.expected, logOutput.String())
+			}
+		})
+	}
+}
+
+func TestNewLoggerUnknownFormat(t *testing.T) {
+	_, err := newLogger(&bytes.Buffer{}, "xml")
+	if err == nil {
+		t.Error("expected an error for an unknown log format")
+	}
+}
@@ -1,68 +1,168 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	exitCodeOK    = 0
+	exitCodeUsage = 2
+	// exitCodeForcedShutdown tells the supervisor that in-flight requests were cut off
+	exitCodeForcedShutdown = 3
 )
 
 type apiConfig struct {
-	fileserverHits int
+	fileserverHits atomic.Int64
+	panics         atomic.Int64
 	db             *DB
+	backups        *backupManager
+	trashRetention time.Duration
+	adminToken     string
 }
 
 func main() {
-	const (
-		port         = "8080"
-		filepathRoot = "."
-	)
+	// (!) maintenance commands like `chirpy export` work on the database without starting the server
+	if len(os.Args) > 1 && isCommand(os.Args[1]) {
+		os.Exit(runCommand(os.Args[1], os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+	}
 
-	db, err := NewDB("database.json")
+	appConfig, printConfig, err := LoadConfig(os.Args[1:], os.LookupEnv, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(exitCodeOK)
+	}
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCodeUsage)
 	}
 
-	cfg := &apiConfig{
-		fileserverHits: 0,
-		db:             db,
+	if printConfig {
+		byteData, err := json.MarshalIndent(appConfig.Redacted(), "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(byteData))
+		os.Exit(exitCodeOK)
 	}
 
-	mux := http.NewServeMux()
-
-	handlerFileserver := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
-	mux.Handle("/app/*", cfg.middlewareMetricsInc(handlerFileserver))
-
-	mux.HandleFunc("POST /api/validate_chirp", handlerValidateChirp)
-
-	mux.HandleFunc("POST /api/chirps", cfg.handlerChirpsPost)
-	mux.HandleFunc("GET /api/chirps", cfg.handlerChirpsGet)
+	logger, err := newLogger(os.Stderr, appConfig.LogFormat)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// (!) this also routes the standard library `log` package through our structured logger
+	slog.SetDefault(logger)
 
-	mux.HandleFunc("GET /api/healthz", handlerReadiness)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// (!) restore the default signal behaviour once we start shutting down so a second Ctrl+C kills the process straight away
+	context.AfterFunc(ctx, stop)
 
-	mux.HandleFunc("GET /api/admin/metrics", cfg.handlerMetrics)
-	mux.HandleFunc("GET /api/admin/reset", cfg.handlerReset)
+	err = Run(ctx, appConfig)
+	if errors.Is(err, ErrForcedShutdown) {
+		os.Exit(exitCodeForcedShutdown)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Exit(exitCodeOK)
+}
 
-	server := &http.Server{
-		Addr: ":" + port,
-		// to apply middleware to all routes we wrap the mux in it
-		Handler: middlewareLog(mux),
+// routes is the routing table, the middleware of a group wraps every route in it and in its nested groups
+// mux is only needed by the CORS middleware to look up which methods a path supports
+func (cfg *apiConfig) routes(mux *http.ServeMux, appConfig Config, logger *slog.Logger, accessLog *rotatingFile, static http.Handler) RouteGroup {
+	// global middleware runs for every request, including the ones no route matches
+	// the first entry is the outermost
+	global := []Middleware{middlewareLog(logger)}
+	if accessLog != nil {
+		global = append(global, middlewareAccessLog(accessLog))
+	}
+	// (!) compression sits inside the loggers so they record the bytes that actually went over the wire
+	if appConfig.Compression {
+		global = append(global, middlewareCompress(appConfig.CompressionMinSize))
+	}
+	// (!) recovery goes innermost so the access log still records the 500
+	global = append(global, cfg.middlewareRecover(logger))
+	if len(appConfig.CORSAllowedOrigins) > 0 {
+		cors := corsPolicy{
+			AllowedOrigins:   appConfig.CORSAllowedOrigins,
+			AllowedMethods:   appConfig.CORSAllowedMethods,
+			AllowedHeaders:   appConfig.CORSAllowedHeaders,
+			ExposedHeaders:   appConfig.CORSExposedHeaders,
+			AllowCredentials: appConfig.CORSAllowCredentials,
+			MaxAge:           appConfig.CORSMaxAge.Duration,
+		}
+		// (!) preflight OPTIONS requests have no route of their own, so CORS has to run before the mux
+		global = append(global, middlewareCORS(cors, mux, "/api/"))
 	}
 
-	log.Printf("serving files from %s on port: %s\n", filepathRoot, port)
-	log.Fatal(server.ListenAndServe())
-}
+	handlerFileserver := http.StripPrefix("/app", static)
 
-func middlewareLog(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("%s %s", r.Method, r.URL.Path)
-		next.ServeHTTP(w, r)
-	})
+	var perIP, perUser *rateLimiter
+	if appConfig.RateLimitIPRate > 0 {
+		perIP = newRateLimiter(appConfig.RateLimitIPRate, appConfig.RateLimitIPBurst)
+	}
+	if appConfig.RateLimitUserRate > 0 {
+		perUser = newRateLimiter(appConfig.RateLimitUserRate, appConfig.RateLimitUserBurst)
+	}
+	rateLimit := middlewareRateLimit(perIP, perUser, cfg.authenticatedUser)
+
+	return RouteGroup{
+		Middleware: global,
+		Groups: []RouteGroup{
+			{
+				Prefix:     "/app",
+				Middleware: []Middleware{cfg.middlewareMetricsInc},
+				Routes: []Route{
+					// (!) a trailing slash matches the whole subtree, "/app" itself redirects to "/app/"
+					{Path: "/", Handler: handlerFileserver},
+				},
+			},
+			{
+				Prefix:     "/api",
+				Middleware: []Middleware{middlewareMaxBytes(appConfig.MaxBodyBytes)},
+				Routes: []Route{
+					{Method: "POST", Path: "/validate_chirp", Handler: http.HandlerFunc(handlerValidateChirp)},
+
+					{Method: "POST", Path: "/chirps", Handler: http.HandlerFunc(cfg.handlerChirpsPost), Middleware: []Middleware{rateLimit}},
+					{Method: "GET", Path: "/chirps", Handler: http.HandlerFunc(cfg.handlerChirpsGet)},
+					// (!) there are no users who could own a chirp yet, so only an admin can delete and restore
+					{Method: "DELETE", Path: "/chirps/{chirpID}", Handler: http.HandlerFunc(cfg.handlerChirpsDelete), Middleware: []Middleware{cfg.middlewareAdminAuth}},
+					{Method: "POST", Path: "/chirps/{chirpID}/restore", Handler: http.HandlerFunc(cfg.handlerChirpsRestore), Middleware: []Middleware{cfg.middlewareAdminAuth}},
+
+					{Method: "GET", Path: "/healthz", Handler: http.HandlerFunc(handlerReadiness)},
+				},
+				Groups: []RouteGroup{
+					{
+						Prefix:     "/admin",
+						Middleware: []Middleware{cfg.middlewareAdminAuth},
+						Routes: []Route{
+							{Method: "GET", Path: "/metrics", Handler: http.HandlerFunc(cfg.handlerMetrics)},
+							{Method: "GET", Path: "/reset", Handler: http.HandlerFunc(cfg.handlerReset)},
+							{Method: "GET", Path: "/export", Handler: http.HandlerFunc(cfg.handlerExport)},
+							{Method: "POST", Path: "/backup", Handler: http.HandlerFunc(cfg.handlerBackup)},
+						},
+					},
+				},
+			},
+		},
+	}
 }
 
 func handlerReadiness(w http.ResponseWriter, r *http.Request) {
@@ -74,6 +174,8 @@ func handlerReadiness(w http.ResponseWriter, r *http.Request) {
 type Chirp struct {
 	ID   int    `json:"id,omitempty"`
 	Body string `json:"body"`
+	// DeletedAt is set while the chirp is in the trash, see DeleteChirp
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type Response struct {
@@ -91,6 +193,13 @@ func handlerValidateChirp(w http.ResponseWriter, r *http.Request) {
 	chirp := Chirp{}
 	err := decoder.Decode(&chirp)
 	if err != nil {
+		// (!) the body is wrapped in http.MaxBytesReader by middlewareMaxBytes
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Chirp payload is larger than %d bytes", maxBytesErr.Limit))
+			return
+		}
+
 		response := Response{
 			Error: "Something went wrong",
 		}
@@ -108,9 +217,10 @@ func handlerValidateChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(chirp.Body) > 140 {
+	err = validateChirp(chirp.Body)
+	if err != nil {
 		response := Response{
-			Error: "Chirp is too long",
+			Error: err.Error(),
 		}
 		byteData, err := json.Marshal(response)
 		if err != nil {
@@ -169,14 +279,88 @@ func handlerValidateChirp(w http.ResponseWriter, r *http.Request) {
 	w.Write(byteData)
 }
 
+// chirpMaxLength is the longest chirp body we accept, in bytes
+const chirpMaxLength = 140
+
+var errChirpTooLong = errors.New("Chirp is too long")
+
+// validateChirp holds the rules every stored chirp follows, whether it was posted or imported
+func validateChirp(body string) error {
+	if len(body) > chirpMaxLength {
+		return errChirpTooLong
+	}
+	return nil
+}
+
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileserverHits++
+		cfg.fileserverHits.Add(1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewareMaxBytes limits every request body to limit bytes,
+// reading past it fails with an *http.MaxBytesError
+func middlewareMaxBytes(limit int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// middlewareAdminAuth requires `Authorization: Bearer <token>`,
+// without a configured admin token every admin request is refused
+func (cfg *apiConfig) middlewareAdminAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cfg.adminToken == "" {
+			// (!) fail closed, these routes reset metrics, dump and delete data
+			respondWithError(w, http.StatusForbidden, "The admin API is disabled, set admin_token to enable it")
+			return
+		}
+
+		if !cfg.isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// isAdmin reports whether r carries the configured admin token
+func (cfg *apiConfig) isAdmin(r *http.Request) bool {
+	if cfg.adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	// (!) constant time comparison so the token can't be guessed from response timings
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.adminToken)) == 1
+}
+
+// authenticatedUser is who r is verifiably authenticated as, "" for anonymous requests
+// there are no user accounts yet, the admin token is the only credential we can check
+func (cfg *apiConfig) authenticatedUser(r *http.Request) string {
+	if cfg.isAdmin(r) {
+		return "admin"
+	}
+	return ""
+}
+
+// metricsResponse is the admin metrics page for clients that ask for JSON
+type metricsResponse struct {
+	Visits int64 `json:"visits"`
+	Panics int64 `json:"panics"`
+}
+
 func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
+	// (!) the page is for people, programs send Accept: application/json and get the numbers
+	addVary(w.Header(), "Accept")
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		respondWithJSON(w, http.StatusOK, metricsResponse{Visits: cfg.fileserverHits.Load(), Panics: cfg.panics.Load()})
+		return
+	}
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf(`
@@ -185,16 +369,17 @@ func (cfg *apiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 <body>
 	<h1>Welcome, Chirpy Admin</h1>
 	<p>Chirpy has been visited %d times!</p>
+	<p>Chirpy handlers have panicked %d times!</p>
 </body>
 
 </html>
-`, cfg.fileserverHits)))
+`, cfg.fileserverHits.Load(), cfg.panics.Load())))
 }
 
 func (cfg *apiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
-	cfg.fileserverHits = 0
+	cfg.fileserverHits.Store(0)
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf("Hits reset to %d", cfg.fileserverHits)))
+	w.Write([]byte(fmt.Sprintf("Hits reset to %d", cfg.fileserverHits.Load())))
 }
 
 func (cfg *apiConfig) handlerChirpsPost(w http.ResponseWriter, r *http.Request) {
@@ -203,6 +388,13 @@ func (cfg *apiConfig) handlerChirpsPost(w http.ResponseWriter, r *http.Request)
 	chirp := Chirp{}
 	err := decoder.Decode(&chirp)
 	if err != nil {
+		// (!) the body is wrapped in http.MaxBytesReader by middlewareMaxBytes
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Chirp payload is larger than %d bytes", maxBytesErr.Limit))
+			return
+		}
+
 		response := Response{
 			Error: "Something went wrong",
 		}
@@ -220,9 +412,10 @@ func (cfg *apiConfig) handlerChirpsPost(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if len(chirp.Body) > 140 {
+	err = validateChirp(chirp.Body)
+	if err != nil {
 		response := Response{
-			Error: "Chirp is too long",
+			Error: err.Error(),
 		}
 		byteData, err := json.Marshal(response)
 		if err != nil {
@@ -239,8 +432,8 @@ func (cfg *apiConfig) handlerChirpsPost(w http.ResponseWriter, r *http.Request)
 
 	chirp, err = cfg.db.CreateChirp(chirp.Body)
 	if err != nil {
-		// TODO handle this error
-		w.WriteHeader(500)
+		log.Printf("error creating chirp: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create chirp")
 		return
 	}
 
@@ -259,8 +452,8 @@ func (cfg *apiConfig) handlerChirpsPost(w http.ResponseWriter, r *http.Request)
 func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
 	chirps, err := cfg.db.GetChirps()
 	if err != nil {
-		// TODO handle error
-		w.WriteHeader(500)
+		log.Printf("error getting chirps: %s", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get chirps")
 		return
 	}
 
@@ -276,37 +469,139 @@ func (cfg *apiConfig) handlerChirpsGet(w http.ResponseWriter, r *http.Request) {
 	w.Write(byteData)
 }
 
+// ErrDBClosed is returned by every DB method once Close has been called
+var ErrDBClosed = errors.New("database is closed")
+
+// DB is the JSON file database
+// mux protects it within this process and fileLock against other processes using the same file
 type DB struct {
-	path string
-	mux  *sync.RWMutex
+	path        string
+	mux         *sync.RWMutex
+	closed      bool
+	fileLock    *fileLock
+	lockTimeout time.Duration
+	keys        *dbKeys
+}
+
+// DBOption configures a DB
+type DBOption func(*DB)
+
+// WithEncryption encrypts the database file with keys, nil keeps it in plaintext
+func WithEncryption(keys *dbKeys) DBOption {
+	return func(db *DB) {
+		db.keys = keys
+	}
+}
+
+// WithLockTimeout sets how long an operation waits for another process to release the database
+func WithLockTimeout(timeout time.Duration) DBOption {
+	return func(db *DB) {
+		db.lockTimeout = timeout
+	}
 }
 
 type DBStructure struct {
-	Chirps map[int]Chirp `json:"chirps"`
+	// SchemaVersion is the shape of the file on disk, see migrations
+	SchemaVersion int `json:"schema_version"`
+	// Checksum covers everything else in the file, see checksum
+	Checksum string        `json:"checksum,omitempty"`
+	Chirps   map[int]Chirp `json:"chirps"`
+	// NextChirpID is the id the next chirp gets, it only ever goes up, see nextChirpID
+	NextChirpID int `json:"next_chirp_id"`
 }
 
-// NewDB creates a new database connection
-// and creates the database file if it doesn't exist
-func NewDB(path string) (*DB, error) {
+// NewDB creates a new database connection,
+// creates the database file if it doesn't exist and upgrades it to the current schema version
+func NewDB(path string, options ...DBOption) (*DB, error) {
 	dbOnDisk := &DB{
-		path: path,
-		mux:  &sync.RWMutex{},
+		path:        path,
+		mux:         &sync.RWMutex{},
+		lockTimeout: defaultLockTimeout,
 	}
-	err := dbOnDisk.ensureDB()
+	for _, option := range options {
+		option(dbOnDisk)
+	}
+
+	var err error
+	dbOnDisk.fileLock, err = openFileLock(lockPath(path))
 	if err != nil {
 		return nil, err
 	}
+	// (!) creating and migrating the file must not race another process doing the same
+	err = dbOnDisk.fileLock.Lock(dbOnDisk.lockTimeout)
+	if err != nil {
+		dbOnDisk.fileLock.Close()
+		return nil, err
+	}
+	defer dbOnDisk.fileLock.Unlock()
+
+	err = dbOnDisk.ensureDB()
+	if err != nil {
+		dbOnDisk.fileLock.Close()
+		return nil, err
+	}
+	applied, err := migrateFile(path, dbOnDisk.keys, false)
+	if err != nil {
+		dbOnDisk.fileLock.Close()
+		return nil, err
+	}
+	for _, migration := range applied {
+		log.Printf("migrated %s: %s", path, migration)
+	}
 	return dbOnDisk, nil
 }
 
+// migrateFile upgrades the database file at path to the current schema version
+// dryRun only reports the migrations that would run
+func migrateFile(path string, keys *dbKeys, dryRun bool) ([]string, error) {
+	byteData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	byteData, err = keys.decrypt(byteData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// (!) migrating reseals the checksum, so a corrupt file has to be caught before
+	err = verifyData(byteData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	migrated, applied, err := migrateData(byteData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if dryRun || len(applied) == 0 {
+		return applied, nil
+	}
+	migrated, err = keys.encrypt(migrated)
+	if err != nil {
+		return applied, err
+	}
+	return applied, writeFileAtomic(path, migrated)
+}
+
 // CreateChirp creates a new chirp and saves it to disk
 func (db *DB) CreateChirp(body string) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.closed {
+		return Chirp{}, ErrDBClosed
+	}
+
+	err := db.fileLock.Lock(db.lockTimeout)
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer db.fileLock.Unlock()
+
 	dbMemory, err := db.loadDB()
 	if err != nil {
-		return Chirp{}, nil
+		return Chirp{}, err
 	}
 	chirp := Chirp{
-		ID:   len(dbMemory.Chirps) + 1,
+		ID:   dbMemory.nextChirpID(),
 		Body: body,
 	}
 	dbMemory.Chirps[chirp.ID] = chirp
@@ -317,14 +612,65 @@ func (db *DB) CreateChirp(body string) (Chirp, error) {
 	return chirp, nil
 }
 
-// GetChirps returns all chirps in the database
+// CreateChirps creates every chirp in bodies with a single write, numbering them in order
+func (db *DB) CreateChirps(bodies []string) ([]Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	err := db.fileLock.Lock(db.lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer db.fileLock.Unlock()
+
+	dbMemory, err := db.loadDB()
+	if err != nil {
+		return nil, err
+	}
+	chirps := make([]Chirp, 0, len(bodies))
+	for _, body := range bodies {
+		chirp := Chirp{
+			ID:   dbMemory.nextChirpID(),
+			Body: body,
+		}
+		dbMemory.Chirps[chirp.ID] = chirp
+		chirps = append(chirps, chirp)
+	}
+	err = db.writeDB(dbMemory)
+	if err != nil {
+		return nil, err
+	}
+	return chirps, nil
+}
+
+// GetChirps returns all chirps in the database except the ones in the trash
 func (db *DB) GetChirps() ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	err := db.fileLock.RLock(db.lockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer db.fileLock.RUnlock()
+
 	dbMemory, err := db.loadDB()
 	if err != nil {
 		return nil, err
 	}
 	chirps := make([]Chirp, 0, len(dbMemory.Chirps))
 	for _, chirp := range dbMemory.Chirps {
+		if chirp.DeletedAt != nil {
+			continue
+		}
 		chirps = append(chirps, chirp)
 	}
 	sort.Slice(chirps, func(a, b int) bool {
@@ -333,6 +679,66 @@ func (db *DB) GetChirps() ([]Chirp, error) {
 	return chirps, nil
 }
 
+// Snapshot copies the database file to w while holding the read lock,
+// so the copy can't catch a write halfway, the copy of an encrypted database is just as encrypted
+func (db *DB) Snapshot(w io.Writer) error {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	err := db.fileLock.RLock(db.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer db.fileLock.RUnlock()
+
+	file, err := os.Open(db.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// Restore replaces the whole database with dbStructure
+func (db *DB) Restore(dbStructure DBStructure) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	err := db.fileLock.Lock(db.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer db.fileLock.Unlock()
+
+	// (!) an older backup must not hand out the ids created since, keep the higher mark when the current file reads
+	current, err := db.loadDB()
+	if err == nil {
+		dbStructure.NextChirpID = max(dbStructure.NextChirpID, current.NextChirpID)
+	}
+	return db.writeDB(dbStructure)
+}
+
+// Close waits for any in-progress write to finish and stops further reads and writes
+func (db *DB) Close() error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	return db.fileLock.Close()
+}
+
 // ensureDB creates a new database file if it doesn't exist
 func (db *DB) ensureDB() error {
 	db.mux.Lock()
@@ -341,16 +747,16 @@ func (db *DB) ensureDB() error {
 	dbExistingFile, err := os.Open(db.path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			dbNewFile, err := os.Create(db.path)
+			// (!) a new file starts at the current schema version with its checksum, so there's nothing to migrate,
+			// and encrypted from the start so it isn't refused as plaintext
+			empty, err := db.encodeDB(DBStructure{
+				Chirps:      map[int]Chirp{},
+				NextChirpID: 1,
+			})
 			if err != nil {
 				return err
 			}
-			defer dbNewFile.Close()
-			_, err = dbNewFile.Write([]byte("{}")) // (!) an empty json object
-			if err != nil {
-				return err
-			}
-			return nil
+			return writeFileAtomic(db.path, empty)
 		} else {
 			return err
 		}
@@ -359,12 +765,20 @@ func (db *DB) ensureDB() error {
 	return nil
 }
 
-// loadDB reads the database file into memory
+// loadDB reads the database file into memory and checks its checksum
+// (!) every error is returned, writing back an empty DBStructure after a failed read would wipe the database
 func (db *DB) loadDB() (DBStructure, error) {
 	byteData, err := os.ReadFile(db.path)
 	if err != nil {
-		log.Println(err)
-		return DBStructure{}, nil
+		return DBStructure{}, err
+	}
+	byteData, err = db.keys.decrypt(byteData)
+	if err != nil {
+		return DBStructure{}, fmt.Errorf("%s: %w", db.path, err)
+	}
+	err = verifyData(byteData)
+	if err != nil {
+		return DBStructure{}, fmt.Errorf("%s: %w", db.path, err)
 	}
 
 	dbInMemory := &DBStructure{
@@ -375,21 +789,65 @@ func (db *DB) loadDB() (DBStructure, error) {
 	if err != nil {
 		return DBStructure{}, err
 	}
+	if dbInMemory.SchemaVersion != currentSchemaVersion {
+		return DBStructure{}, fmt.Errorf("database schema version %d, expected %d", dbInMemory.SchemaVersion, currentSchemaVersion)
+	}
 
 	return *dbInMemory, nil
 }
 
 // writeDB writes the database file to disk
 func (db *DB) writeDB(dbStructure DBStructure) error {
+	json, err := db.encodeDB(dbStructure)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(db.path, json)
+}
+
+// encodeDB stamps dbStructure with the current schema version, seals and encrypts it
+func (db *DB) encodeDB(dbStructure DBStructure) ([]byte, error) {
+	dbStructure.SchemaVersion = currentSchemaVersion
+	dbStructure.Checksum = ""
 	json, err := json.Marshal(dbStructure)
+	if err != nil {
+		return nil, err
+	}
+	json, err = sealData(json)
+	if err != nil {
+		return nil, err
+	}
+	// (!) always with the current key, this is what re-encrypts a database still using an old one
+	return db.keys.encrypt(json)
+}
+
+// writeFileAtomic writes to a temporary file and renames it over path
+// so a crash mid-write can never leave a half written file behind
+func writeFileAtomic(path string, byteData []byte) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmpFile.Name())
 
-	err = os.WriteFile(db.path, json, 0644)
+	_, err = tmpFile.Write(byteData)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Sync()
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmpFile.Name(), 0644)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return os.Rename(tmpFile.Name(), path)
 }
@@ -7,27 +7,75 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"net/http/httptest"
+	"path/filepath"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
-	"time"
 )
 
-func Setup(t *testing.T) *http.Client {
+// testAdminToken is the admin token of the servers Setup starts
+const testAdminToken = "s3cret"
+
+// Setup serves a fresh Chirpy with its own database in a temporary directory,
+// so every test can run in parallel with the others
+func Setup(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	return newTestServer(t, newTestConfig(t))
+}
+
+// newTestConfig is DefaultConfig with the database and the backups in a temporary directory and testAdminToken
+func newTestConfig(t *testing.T) Config {
 	t.Helper()
 
-	go main()
-	time.Sleep(100 * time.Millisecond)
+	appConfig := DefaultConfig()
+	appConfig.DBPath = filepath.Join(t.TempDir(), "database.json")
+	appConfig.BackupDir = filepath.Join(t.TempDir(), "backups")
+	appConfig.AdminToken = testAdminToken
+	return appConfig
+}
+
+// adminRequest sends a request with testAdminToken, the caller closes the body
+func adminRequest(t *testing.T, client *http.Client, method, url string) *http.Response {
+	t.Helper()
 
-	client := &http.Client{}
-	return client
+	request, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+testAdminToken)
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+// newTestServer serves appConfig until the test ends
+func newTestServer(t *testing.T, appConfig Config) *httptest.Server {
+	t.Helper()
+
+	srv, err := NewServer(appConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testServer := httptest.NewServer(srv)
+	t.Cleanup(func() {
+		testServer.Close()
+		srv.Close()
+	})
+	return testServer
 }
 
 func TestApp(t *testing.T) {
-	client := Setup(t)
+	t.Parallel()
+
+	testServer := Setup(t)
+	client := testServer.Client()
 
-	response, err := client.Get("http://localhost:8080/app")
+	response, err := client.Get(testServer.URL + "/app")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -50,9 +98,25 @@ func TestApp(t *testing.T) {
 }
 
 func TestAssets(t *testing.T) {
-	client := Setup(t)
+	t.Parallel()
 
-	response, err := client.Get("http://localhost:8080/app/assets/")
+	// (!) directory listings are opt-in
+	defaultServer := Setup(t)
+	response, err := defaultServer.Client().Get(defaultServer.URL + "/app/assets/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status code %d without static_dir_listing | got %d", http.StatusNotFound, response.StatusCode)
+	}
+
+	appConfig := newTestConfig(t)
+	appConfig.StaticDirListing = true
+	testServer := newTestServer(t, appConfig)
+	client := testServer.Client()
+
+	response, err = client.Get(testServer.URL + "/app/assets/")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -75,9 +139,12 @@ func TestAssets(t *testing.T) {
 }
 
 func TestAssetsImage(t *testing.T) {
-	client := Setup(t)
+	t.Parallel()
 
-	response, err := client.Get("http://localhost:8080/app/assets/logo.png")
+	testServer := Setup(t)
+	client := testServer.Client()
+
+	response, err := client.Get(testServer.URL + "/app/assets/logo.png")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -97,9 +164,12 @@ func TestAssetsImage(t *testing.T) {
 }
 
 func TestHealthz(t *testing.T) {
-	client := Setup(t)
+	t.Parallel()
+
+	testServer := Setup(t)
+	client := testServer.Client()
 
-	response, err := client.Get("http://localhost:8080/api/healthz")
+	response, err := client.Get(testServer.URL + "/api/healthz")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -128,22 +198,22 @@ func TestHealthz(t *testing.T) {
 }
 
 func TestMetrics(t *testing.T) {
-	client := Setup(t)
+	t.Parallel()
+
+	testServer := Setup(t)
+	client := testServer.Client()
 
 	visitCount := 5
 
 	for i := 0; i < visitCount; i++ {
-		response, err := client.Get("http://localhost:8080/app")
+		response, err := client.Get(testServer.URL + "/app")
 		if err != nil {
 			t.Fatal(err)
 		}
 		defer response.Body.Close()
 	}
 
-	response, err := client.Get("http://localhost:8080/api/admin/metrics")
-	if err != nil {
-		t.Fatal(err)
-	}
+	response := adminRequest(t, client, "GET", testServer.URL+"/api/admin/metrics")
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
@@ -161,23 +231,43 @@ func TestMetrics(t *testing.T) {
 	if !containsVisitCount {
 		t.Errorf("expected %t | got %t", true, containsVisitCount)
 	}
+
+	request, err := http.NewRequest("GET", testServer.URL+"/api/admin/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+testAdminToken)
+	request.Header.Set("Accept", "application/json")
+	response, err = client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	metrics := metricsResponse{}
+	err = json.NewDecoder(response.Body).Decode(&metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics != (metricsResponse{Visits: int64(visitCount)}) {
+		t.Errorf("expected %+v | got %+v", metricsResponse{Visits: int64(visitCount)}, metrics)
+	}
 }
 
 func TestReset(t *testing.T) {
-	client := Setup(t)
+	t.Parallel()
+
+	testServer := Setup(t)
+	client := testServer.Client()
 
 	for i := 0; i < 5; i++ {
-		response, err := client.Get("http://localhost:8080/app")
+		response, err := client.Get(testServer.URL + "/app")
 		if err != nil {
 			t.Fatal(err)
 		}
 		defer response.Body.Close()
 	}
 
-	response, err := client.Get("http://localhost:8080/api/admin/reset")
-	if err != nil {
-		t.Fatal(err)
-	}
+	response := adminRequest(t, client, "GET", testServer.URL+"/api/admin/reset")
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK {
@@ -197,13 +287,60 @@ func TestReset(t *testing.T) {
 	}
 }
 
+func TestMetricsConcurrentVisits(t *testing.T) {
+	t.Parallel()
+
+	testServer := Setup(t)
+	client := testServer.Client()
+
+	// (!) run with -race, every /app request counts from its own goroutine
+	const visitCount = 8
+	var wg sync.WaitGroup
+	for range visitCount {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := client.Get(testServer.URL + "/app/")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			response.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	request, err := http.NewRequest("GET", testServer.URL+"/api/admin/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+testAdminToken)
+	request.Header.Set("Accept", "application/json")
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	metrics := metricsResponse{}
+	err = json.NewDecoder(response.Body).Decode(&metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.Visits != visitCount {
+		t.Errorf("expected %d visits | got %d", visitCount, metrics.Visits)
+	}
+}
+
 func TestMethodRestriction(t *testing.T) {
-	client := Setup(t)
+	t.Parallel()
+
+	testServer := Setup(t)
+	client := testServer.Client()
 
 	urls := []string{
-		"http://localhost:8080/api/healthz",
-		"http://localhost:8080/api/admin/metrics",
-		"http://localhost:8080/api/admin/reset",
+		testServer.URL + "/api/healthz",
+		testServer.URL + "/api/admin/metrics",
+		testServer.URL + "/api/admin/reset",
 	}
 
 	for _, url := range urls {
@@ -218,7 +355,10 @@ func TestMethodRestriction(t *testing.T) {
 }
 
 func TestValidateChirp(t *testing.T) {
-	client := Setup(t)
+	t.Parallel()
+
+	testServer := Setup(t)
+	client := testServer.Client()
 
 	testCases := []struct {
 		name               string
@@ -270,7 +410,7 @@ func TestValidateChirp(t *testing.T) {
 			// 2. Efficiency: It creates a reader without copying the data, using less memory than alternatives like `bytes.Buffer`.
 			// 3. Simplicity: It provides a read-only view of the data, which is sufficient for sending an HTTP request.
 			// this approach is memory-efficient, simple, and aligns with Go's idiomatic practices for handling byte slices in HTTP requests.
-			response, err := client.Post("http://localhost:8080/api/validate_chirp", "application/json", bytes.NewReader(requestBodyJson))
+			response, err := client.Post(testServer.URL+"/api/validate_chirp", "application/json", bytes.NewReader(requestBodyJson))
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -303,9 +443,10 @@ func TestValidateChirp(t *testing.T) {
 }
 
 func TestPostChirps(t *testing.T) {
-	DeleteDBFile(t)
+	t.Parallel()
 
-	client := Setup(t)
+	testServer := Setup(t)
+	client := testServer.Client()
 
 	requestBody := Chirp{Body: "I had something interesting for breakfast"}
 
@@ -314,7 +455,7 @@ func TestPostChirps(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	response, err := client.Post("http://localhost:8080/api/chirps", "application/json", bytes.NewReader(requestBodyJson))
+	response, err := client.Post(testServer.URL+"/api/chirps", "application/json", bytes.NewReader(requestBodyJson))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -345,10 +486,25 @@ func TestPostChirps(t *testing.T) {
 }
 
 func TestGetChirps(t *testing.T) {
-	// TODO (!) in order to pass the above POST function has to run
-	client := Setup(t)
+	t.Parallel()
+
+	testServer := Setup(t)
+	client := testServer.Client()
 
-	response, err := client.Get("http://localhost:8080/api/chirps")
+	requestBodyJson, err := json.Marshal(Chirp{Body: "I had something interesting for breakfast"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	postResponse, err := client.Post(testServer.URL+"/api/chirps", "application/json", bytes.NewReader(requestBodyJson))
+	if err != nil {
+		t.Fatal(err)
+	}
+	postResponse.Body.Close()
+	if postResponse.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status code %d | got %d", http.StatusCreated, postResponse.StatusCode)
+	}
+
+	response, err := client.Get(testServer.URL + "/api/chirps")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -386,9 +542,9 @@ func TestGetChirps(t *testing.T) {
 }
 
 func TestDB(t *testing.T) {
-	DeleteDBFile(t)
+	t.Parallel()
 
-	dbDisk, err := NewDB("database.json")
+	dbDisk, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -416,16 +572,91 @@ func TestDB(t *testing.T) {
 	}
 }
 
-func DeleteDBFile(t *testing.T) {
-	t.Helper()
-	_, err := os.Stat("database.json")
+func TestDBClose(t *testing.T) {
+	t.Parallel()
+
+	dbDisk, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
 	if err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			return
-		}
+		t.Fatal(err)
 	}
-	err = os.Remove("database.json")
+
+	err = dbDisk.Close()
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	_, err = dbDisk.CreateChirp("too late")
+	if !errors.Is(err, ErrDBClosed) {
+		t.Errorf("expected %v | got %v", ErrDBClosed, err)
+	}
+
+	_, err = dbDisk.GetChirps()
+	if !errors.Is(err, ErrDBClosed) {
+		t.Errorf("expected %v | got %v", ErrDBClosed, err)
+	}
+}
+
+func TestMiddlewareAdminAuth(t *testing.T) {
+	testCases := []struct {
+		name               string
+		adminToken         string
+		authorization      string
+		expectedStatusCode int
+	}{
+		{name: "missing token", adminToken: "s3cret", authorization: "", expectedStatusCode: http.StatusUnauthorized},
+		{name: "wrong token", adminToken: "s3cret", authorization: "Bearer nope", expectedStatusCode: http.StatusUnauthorized},
+		{name: "correct token", adminToken: "s3cret", authorization: "Bearer s3cret", expectedStatusCode: http.StatusOK},
+		{name: "no admin token configured", adminToken: "", authorization: "", expectedStatusCode: http.StatusForbidden},
+		{name: "empty bearer without admin token", adminToken: "", authorization: "Bearer ", expectedStatusCode: http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &apiConfig{adminToken: tc.adminToken}
+			handler := cfg.middlewareAdminAuth(http.HandlerFunc(handlerReadiness))
+
+			request := httptest.NewRequest("GET", "/api/admin/metrics", nil)
+			if tc.authorization != "" {
+				request.Header.Set("Authorization", tc.authorization)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d | got %d", tc.expectedStatusCode, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	handler := middlewareMaxBytes(64)(http.HandlerFunc(handlerValidateChirp))
+
+	requestBodyJson, err := json.Marshal(Chirp{Body: strings.Repeat("a", 100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest("POST", "/api/validate_chirp", bytes.NewReader(requestBodyJson))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status code %d | got %d", http.StatusRequestEntityTooLarge, recorder.Code)
+	}
+
+	if recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected Content-Type %s | got %s", "application/json", recorder.Header().Get("Content-Type"))
+	}
+
+	var responseJSON Response
+	err = json.Unmarshal(recorder.Body.Bytes(), &responseJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedError := "Chirp payload is larger than 64 bytes"
+	if responseJSON.Error != expectedError {
+		t.Errorf("expected %s | got %s", expectedError, responseJSON.Error)
+	}
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// migration upgrades the database by one schema version
+// it works on the raw top level keys so it never depends on how DBStructure looks today
+type migration struct {
+	description string
+	migrate     func(db map[string]json.RawMessage) error
+}
+
+// migrations upgrade the database from the version at their index to the next one,
+// only ever append to this list: a shipped migration must never change
+var migrations = []migration{
+	// version 0 is database.json as it was before schema_version existed, `{}` until the first chirp
+	{
+		description: "add schema_version and an empty chirps collection",
+		migrate: func(db map[string]json.RawMessage) error {
+			if _, ok := db["chirps"]; !ok {
+				db["chirps"] = json.RawMessage(`{}`)
+			}
+			return nil
+		},
+	},
+	{
+		description: "add a checksum of the data",
+		migrate: func(db map[string]json.RawMessage) error {
+			// (!) migrateData stores the checksum once every migration has run
+			return nil
+		},
+	},
+	{
+		// nothing to convert, but a binary that doesn't know deleted_at would bring back every chirp in the trash
+		description: "keep soft deleted chirps with a deleted_at timestamp",
+		migrate: func(db map[string]json.RawMessage) error {
+			return nil
+		},
+	},
+	{
+		// (!) the best guess for the ids purged so far is whatever is left, from here on nothing gets reused
+		description: "store next_chirp_id so the ids of purged chirps are never handed out again",
+		migrate: func(db map[string]json.RawMessage) error {
+			chirps := map[int]json.RawMessage{}
+			err := json.Unmarshal(db["chirps"], &chirps)
+			if err != nil {
+				return err
+			}
+			next := 1
+			for id := range chirps {
+				next = max(next, id+1)
+			}
+			db["next_chirp_id"] = json.RawMessage(strconv.Itoa(next))
+			return nil
+		},
+	},
+}
+
+// currentSchemaVersion is the version writeDB stamps on the database
+var currentSchemaVersion = len(migrations)
+
+// schemaVersion reads the version of a raw database, a missing schema_version is version 0
+func schemaVersion(db map[string]json.RawMessage) (int, error) {
+	raw, ok := db["schema_version"]
+	if !ok {
+		return 0, nil
+	}
+	version := 0
+	err := json.Unmarshal(raw, &version)
+	if err != nil {
+		return 0, fmt.Errorf("invalid schema_version %s: %w", raw, err)
+	}
+	return version, nil
+}
+
+// migrateData upgrades byteData to currentSchemaVersion
+// it returns the descriptions of the migrations it ran, byteData is returned as is when there were none
+func migrateData(byteData []byte) ([]byte, []string, error) {
+	db := map[string]json.RawMessage{}
+	err := json.Unmarshal(byteData, &db)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version > currentSchemaVersion {
+		// (!) an older binary would silently drop whatever the newer schema added
+		return nil, nil, fmt.Errorf("database schema version %d is newer than the %d this binary supports", version, currentSchemaVersion)
+	}
+	if version < 0 {
+		return nil, nil, fmt.Errorf("invalid schema_version %d", version)
+	}
+
+	var applied []string
+	for ; version < currentSchemaVersion; version++ {
+		m := migrations[version]
+		err := m.migrate(db)
+		if err != nil {
+			return nil, applied, fmt.Errorf("migrating schema version %d to %d (%s): %w", version, version+1, m.description, err)
+		}
+		db["schema_version"] = json.RawMessage(fmt.Sprint(version + 1))
+		applied = append(applied, fmt.Sprintf("%d -> %d: %s", version, version+1, m.description))
+	}
+	if len(applied) == 0 {
+		return byteData, nil, nil
+	}
+	// the migrations changed the data, so the old checksum (if there was one) can't match anymore
+	if version >= checksumSchemaVersion {
+		err = seal(db)
+		if err != nil {
+			return nil, applied, err
+		}
+	}
+
+	byteData, err = json.Marshal(db)
+	if err != nil {
+		return nil, applied, err
+	}
+	return byteData, applied, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// every historical schema version needs a fixture in testdata/migrations named v<version>*.json
+var migrationFixtures = map[string]map[int]Chirp{
+	"v0-empty.json": {},
+	"v0.json": {
+		1: {ID: 1, Body: "I had something interesting for breakfast"},
+		2: {ID: 2, Body: "What about second breakfast?"},
+	},
+	"v1.json": {
+		1: {ID: 1, Body: "I had something interesting for breakfast"},
+	},
+	"v2.json": {
+		1: {ID: 1, Body: "I had something interesting for breakfast"},
+		2: {ID: 2, Body: "What about second breakfast?"},
+		3: {ID: 3, Body: "And elevenses?"},
+	},
+	"v3.json": {
+		1: {ID: 1, Body: "I had something interesting for breakfast"},
+		2: {ID: 2, Body: "What about second breakfast?", DeletedAt: &fixtureDeletedAt},
+		3: {ID: 3, Body: "And elevenses?"},
+	},
+	"v4.json": {
+		1: {ID: 1, Body: "I had something interesting for breakfast"},
+		3: {ID: 3, Body: "And elevenses?", DeletedAt: &fixtureDeletedAt},
+	},
+}
+
+var fixtureDeletedAt = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func TestMigrationFixturesCoverEveryVersion(t *testing.T) {
+	for version := 0; version <= currentSchemaVersion; version++ {
+		matches, err := filepath.Glob(filepath.Join("testdata", "migrations", fmt.Sprintf("v%d*.json", version)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(matches) == 0 {
+			t.Errorf("expected a fixture for schema version %d in testdata/migrations", version)
+		}
+		for _, match := range matches {
+			if _, ok := migrationFixtures[filepath.Base(match)]; !ok {
+				t.Errorf("expected %s to be listed in migrationFixtures", match)
+			}
+		}
+	}
+}
+
+func TestMigrateFixtures(t *testing.T) {
+	for name, expectedChirps := range migrationFixtures {
+		t.Run(name, func(t *testing.T) {
+			byteData, err := os.ReadFile(filepath.Join("testdata", "migrations", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			migrated, _, err := migrateData(byteData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = verifyData(migrated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dbStructure := DBStructure{}
+			err = json.Unmarshal(migrated, &dbStructure)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dbStructure.SchemaVersion != currentSchemaVersion {
+				t.Errorf("expected schema version %d | got %d", currentSchemaVersion, dbStructure.SchemaVersion)
+			}
+			if !reflect.DeepEqual(dbStructure.Chirps, expectedChirps) {
+				t.Errorf("expected %v | got %v", expectedChirps, dbStructure.Chirps)
+			}
+
+			// (!) migrating twice changes nothing
+			again, applied, err := migrateData(migrated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(applied) != 0 || !bytes.Equal(again, migrated) {
+				t.Errorf("expected no migrations the second time | got %v", applied)
+			}
+		})
+	}
+}
+
+func TestMigrateNextChirpID(t *testing.T) {
+	for name, expected := range map[string]int{"v0-empty.json": 1, "v3.json": 4, "v4.json": 5} {
+		byteData, err := os.ReadFile(filepath.Join("testdata", "migrations", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		migrated, _, err := migrateData(byteData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dbStructure := DBStructure{}
+		err = json.Unmarshal(migrated, &dbStructure)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dbStructure.NextChirpID != expected {
+			t.Errorf("%s: expected next_chirp_id %d | got %d", name, expected, dbStructure.NextChirpID)
+		}
+	}
+}
+
+func TestMigrateNewerVersion(t *testing.T) {
+	_, _, err := migrateData([]byte(fmt.Sprintf(`{"schema_version":%d,"chirps":{}}`, currentSchemaVersion+1)))
+	if err == nil {
+		t.Error("expected an error for a schema version newer than the binary")
+	}
+}
+
+func TestMigrateFileDryRun(t *testing.T) {
+	original, err := os.ReadFile(filepath.Join("testdata", "migrations", "v0.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "database.json")
+	err = os.WriteFile(path, original, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := migrateFile(path, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != currentSchemaVersion {
+		t.Errorf("expected %d migrations | got %v", currentSchemaVersion, applied)
+	}
+	afterDryRun, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(afterDryRun, original) {
+		t.Errorf("expected a dry run to leave the file alone | got %s", afterDryRun)
+	}
+
+	// NewDB runs the migrations for real
+	db, err := NewDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirps, err := db.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 2 {
+		t.Errorf("expected 2 chirps | got %v", chirps)
+	}
+	applied, err = migrateFile(path, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("expected no pending migrations | got %v", applied)
+	}
+}
+
+func TestEnsureDBStartsAtCurrentVersion(t *testing.T) {
+	cases := map[string]*dbKeys{
+		"plaintext": nil,
+		"encrypted": mustDBKeys(t, testKey(1)),
+	}
+
+	for name, keys := range cases {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "database.json")
+			// (!) ensureDB on its own, NewDB would hide a stale new file by migrating it right away
+			db := &DB{path: path, mux: &sync.RWMutex{}, keys: keys}
+			err := db.ensureDB()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			applied, err := migrateFile(path, keys, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(applied) != 0 {
+				t.Errorf("expected a new database to need no migrations | got %v", applied)
+			}
+
+			// loadDB checks the checksum and the schema version
+			dbStructure, err := db.loadDB()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(dbStructure.Chirps) != 0 || dbStructure.NextChirpID != 1 {
+				t.Errorf("expected an empty database starting at chirp id 1 | got %+v", dbStructure)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket holds up to burst tokens and refills at rate tokens per second
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one token bucket per key (an IP address or a user)
+type rateLimiter struct {
+	rate  float64
+	burst int
+
+	mux         sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+// rateLimitDecision is what a rateLimiter tells the middleware about a single request
+type rateLimitDecision struct {
+	allowed   bool
+	remaining int
+	// reset is how long until the bucket is full again
+	reset time.Duration
+	// retryAfter is how long until the next token, only set when the request was refused
+	retryAfter time.Duration
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token from key's bucket if there is one
+func (rl *rateLimiter) allow(key string) rateLimitDecision {
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+
+	now := rl.now()
+	rl.cleanup(now)
+
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(rl.burst), last: now}
+		rl.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = math.Min(float64(rl.burst), bucket.tokens+elapsed*rl.rate)
+	bucket.last = now
+
+	decision := rateLimitDecision{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		decision.allowed = true
+	} else {
+		decision.retryAfter = rl.secondsToDuration((1 - bucket.tokens) / rl.rate)
+	}
+	decision.remaining = int(bucket.tokens)
+	decision.reset = rl.secondsToDuration((float64(rl.burst) - bucket.tokens) / rl.rate)
+	return decision
+}
+
+// refund gives back the token allow took, for a request that another limiter refused
+func (rl *rateLimiter) refund(key string) {
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+
+	bucket, ok := rl.buckets[key]
+	if ok {
+		bucket.tokens = math.Min(float64(rl.burst), bucket.tokens+1)
+	}
+}
+
+// cleanup drops buckets that have refilled completely, a full bucket is the same as no bucket at all
+// this runs at most once per refill period so the cost is spread over many requests
+func (rl *rateLimiter) cleanup(now time.Time) {
+	refill := rl.secondsToDuration(float64(rl.burst) / rl.rate)
+	if now.Sub(rl.lastCleanup) < refill {
+		return
+	}
+	rl.lastCleanup = now
+
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.last) >= refill {
+			delete(rl.buckets, key)
+		}
+	}
+}
+
+func (rl *rateLimiter) secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// middlewareRateLimit refuses requests with a 429 once the client's IP address or user runs out of tokens
+// a nil limiter disables that check, user returns who the request is authenticated as or "" when it isn't
+// (!) user must only return identities it verified, an unverified token would let a client
+// rotate random tokens and get a fresh bucket every time
+func middlewareRateLimit(perIP, perUser *rateLimiter, user func(r *http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			type limit struct {
+				limiter  *rateLimiter
+				key      string
+				decision rateLimitDecision
+			}
+			var limits []limit
+
+			if perIP != nil {
+				key := clientIP(r)
+				limits = append(limits, limit{perIP, key, perIP.allow(key)})
+			}
+			if perUser != nil {
+				if key := user(r); key != "" {
+					limits = append(limits, limit{perUser, key, perUser.allow(key)})
+				}
+			}
+			if len(limits) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// (!) report the most restrictive of the limits that apply to this request, a refusal always wins
+			strictest := limits[0]
+			for _, l := range limits[1:] {
+				if strictest.decision.allowed && !l.decision.allowed ||
+					strictest.decision.allowed == l.decision.allowed && l.decision.remaining < strictest.decision.remaining {
+					strictest = l
+				}
+			}
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(strictest.limiter.burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(strictest.decision.remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(strictest.decision.reset)))
+
+			if !strictest.decision.allowed {
+				// (!) a refused request must not cost the client tokens in the limits that did allow it
+				for _, l := range limits {
+					if l.decision.allowed {
+						l.limiter.refund(l.key)
+					}
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(strictest.decision.retryAfter)))
+				respondWithError(w, http.StatusTooManyRequests, "Too many requests, slow down")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientIP is the address of the TCP peer
+// (!) X-Forwarded-For is deliberately ignored, any client can set it to dodge the limit
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(1, 2)
+	limiter.now = func() time.Time { return clock }
+
+	for i := 0; i < 2; i++ {
+		if !limiter.allow("a").allowed {
+			t.Fatalf("expected request %d to be allowed", i+1)
+		}
+	}
+
+	refused := limiter.allow("a")
+	if refused.allowed {
+		t.Fatal("expected the third request to be refused")
+	}
+	if refused.retryAfter != time.Second {
+		t.Errorf("expected retry after %s | got %s", time.Second, refused.retryAfter)
+	}
+
+	// other keys have their own bucket
+	if !limiter.allow("b").allowed {
+		t.Error("expected another key to be allowed")
+	}
+
+	clock = clock.Add(time.Second)
+	if !limiter.allow("a").allowed {
+		t.Error("expected a refilled token to be allowed")
+	}
+
+	// buckets that have refilled completely are dropped
+	clock = clock.Add(time.Hour)
+	limiter.allow("c")
+	if len(limiter.buckets) != 1 {
+		t.Errorf("expected %d bucket after cleanup | got %d", 1, len(limiter.buckets))
+	}
+}
+
+func TestMiddlewareRateLimit(t *testing.T) {
+	// only "user-token" is a verified user, any other token is as good as none
+	user := func(r *http.Request) string {
+		if r.Header.Get("Authorization") == "Bearer user-token" {
+			return "user"
+		}
+		return ""
+	}
+	perIP, perUser := newRateLimiter(1, 5), newRateLimiter(1, 1)
+	handler := middlewareRateLimit(perIP, perUser, user)(http.HandlerFunc(handlerReadiness))
+
+	send := func(remoteAddr, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/api/chirps", nil)
+		request.RemoteAddr = remoteAddr
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	response := send("192.0.2.1:1000", "")
+	if response.Code != http.StatusOK {
+		t.Fatalf("expected status code %d | got %d", http.StatusOK, response.Code)
+	}
+	if response.Header().Get("RateLimit-Limit") != "5" || response.Header().Get("RateLimit-Remaining") != "4" {
+		t.Errorf("expected limit 5 and 4 remaining | got %s and %s", response.Header().Get("RateLimit-Limit"), response.Header().Get("RateLimit-Remaining"))
+	}
+
+	// the user bucket only holds one token, even when the user moves between IPs
+	send("192.0.2.2:1000", "user-token")
+	response = send("192.0.2.3:1000", "user-token")
+	if response.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status code %d | got %d", http.StatusTooManyRequests, response.Code)
+	}
+	if response.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected Retry-After %s | got %s", "1", response.Header().Get("Retry-After"))
+	}
+	if response.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected Content-Type %s | got %s", "application/json", response.Header().Get("Content-Type"))
+	}
+
+	// (!) the refused request didn't cost 192.0.2.3 a token of its own IP bucket
+	response = send("192.0.2.3:1000", "")
+	if response.Header().Get("RateLimit-Remaining") != "4" {
+		t.Errorf("expected 4 remaining for an IP whose only request was refused | got %s", response.Header().Get("RateLimit-Remaining"))
+	}
+
+	// the IP bucket runs out after five requests, unverified tokens don't get a bucket of their own
+	for i := 0; i < 4; i++ {
+		send("192.0.2.1:1000", "random-"+strconv.Itoa(i))
+	}
+	response = send("192.0.2.1:1000", "yet-another-random-token")
+	if response.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status code %d | got %d", http.StatusTooManyRequests, response.Code)
+	}
+	if len(perUser.buckets) != 1 {
+		t.Errorf("expected only the verified user to have a bucket | got %d buckets", len(perUser.buckets))
+	}
+}
+
+func TestRateLimiterRefund(t *testing.T) {
+	clock := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter := newRateLimiter(1, 1)
+	limiter.now = func() time.Time { return clock }
+
+	limiter.allow("a")
+	limiter.refund("a")
+	limiter.refund("a")
+	if !limiter.allow("a").allowed {
+		t.Fatal("expected the refunded token to be allowed")
+	}
+	if limiter.allow("a").allowed {
+		t.Error("expected refunds to never fill the bucket past burst")
+	}
+}
+
+func TestAuthenticatedUser(t *testing.T) {
+	cfg := &apiConfig{adminToken: "s3cret"}
+	for _, tc := range []struct {
+		authorization string
+		expected      string
+	}{
+		{authorization: "Bearer s3cret", expected: "admin"},
+		{authorization: "Bearer guessed", expected: ""},
+		{authorization: "", expected: ""},
+	} {
+		request := httptest.NewRequest("POST", "/api/chirps", nil)
+		if tc.authorization != "" {
+			request.Header.Set("Authorization", tc.authorization)
+		}
+		if got := cfg.authenticatedUser(request); got != tc.expected {
+			t.Errorf("%q: expected user %q | got %q", tc.authorization, tc.expected, got)
+		}
+	}
+
+	// without an admin token even an empty bearer token must not match
+	request := httptest.NewRequest("POST", "/api/chirps", nil)
+	request.Header.Set("Authorization", "Bearer ")
+	if got := (&apiConfig{}).authenticatedUser(request); got != "" {
+		t.Errorf("expected no user without an admin token | got %q", got)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Problem is an RFC 9457 problem details body, sent as application/problem+json
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// respondWithProblem writes a problem+json body for the given status code
+func respondWithProblem(w http.ResponseWriter, r *http.Request, code int, detail string) {
+	problem := Problem{
+		// (!) "about:blank" means the status code says it all
+		Type:      "about:blank",
+		Title:     http.StatusText(code),
+		Status:    code,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: requestIDFromContext(r.Context()),
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	respondWithJSONBody(w, code, problem)
+}
+
+// middlewareRecover turns a panic in next into a 500 instead of a dropped connection
+// it has to sit inside middlewareLog so the request ID is available
+func (cfg *apiConfig) middlewareRecover(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lw := &loggingResponseWriter{ResponseWriter: w}
+
+			defer func() {
+				recovered := recover()
+				if recovered == nil {
+					return
+				}
+				// (!) http.ErrAbortHandler is how a handler deliberately aborts a response, net/http handles it quietly
+				if recovered == http.ErrAbortHandler {
+					panic(recovered)
+				}
+
+				cfg.panics.Add(1)
+				logger.ErrorContext(r.Context(), "handler panicked",
+					slog.String("request_id", requestIDFromContext(r.Context())),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.Any("panic", recovered),
+					slog.String("stack", string(debug.Stack())),
+				)
+
+				// once the status line has gone out all we can do is cut the response short
+				if lw.status != 0 {
+					panic(http.ErrAbortHandler)
+				}
+				respondWithProblem(lw, r, http.StatusInternalServerError, "The server hit an unexpected error, please quote the request ID when reporting it")
+			}()
+
+			next.ServeHTTP(lw, r)
+		})
+	}
+}
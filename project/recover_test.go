@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareRecover(t *testing.T) {
+	var logOutput bytes.Buffer
+	logger, err := newLogger(&logOutput, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &apiConfig{}
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(errors.New("boom"))
+	}), middlewareLog(logger), cfg.middlewareRecover(logger))
+
+	request := httptest.NewRequest("GET", "/api/chirps", nil)
+	request.Header.Set(headerRequestID, "req-42")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected status code %d | got %d", http.StatusInternalServerError, recorder.Code)
+	}
+	if recorder.Header().Get("Content-Type") != "application/problem+json" {
+		t.Errorf("expected Content-Type %s | got %s", "application/problem+json", recorder.Header().Get("Content-Type"))
+	}
+
+	var problem Problem
+	err = json.Unmarshal(recorder.Body.Bytes(), &problem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if problem.Status != http.StatusInternalServerError || problem.RequestID != "req-42" || problem.Instance != "/api/chirps" {
+		t.Errorf("unexpected problem %+v", problem)
+	}
+
+	if cfg.panics.Load() != 1 {
+		t.Errorf("expected %d panics | got %d", 1, cfg.panics.Load())
+	}
+
+	logged := logOutput.String()
+	if !strings.Contains(logged, `"panic":"boom"`) || !strings.Contains(logged, `"request_id":"req-42"`) || !strings.Contains(logged, "goroutine") {
+		t.Errorf("expected the panic, request id and stack trace to be logged | got %s", logged)
+	}
+
+	metricsRecorder := httptest.NewRecorder()
+	cfg.handlerMetrics(metricsRecorder, httptest.NewRequest("GET", "/api/admin/metrics", nil))
+	if !strings.Contains(metricsRecorder.Body.String(), "<p>Chirpy handlers have panicked 1 times!</p>") {
+		t.Errorf("expected the metrics page to show the panic count | got %s", metricsRecorder.Body.String())
+	}
+}
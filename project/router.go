@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Middleware wraps a handler with extra behaviour
+type Middleware func(next http.Handler) http.Handler
+
+// Chain wraps handler in middleware, the first middleware is the outermost
+// so Chain(h, a, b) runs a, then b, then h
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// Route is a single entry in the routing table
+type Route struct {
+	// Method is optional, an empty Method matches every method
+	Method string
+	// Path is appended to the prefixes of the enclosing groups
+	// and can use the Go 1.22 wildcards e.g. "/chirps/{chirpID}"
+	Path       string
+	Handler    http.Handler
+	Middleware []Middleware
+}
+
+// RouteGroup shares a path prefix and a middleware stack between routes and nested groups
+type RouteGroup struct {
+	Prefix     string
+	Middleware []Middleware
+	Routes     []Route
+	Groups     []RouteGroup
+}
+
+// NewRouter registers every route in root on mux
+// root.Middleware wraps the whole mux so it also runs for requests that match no route (404s, 405s),
+// the middleware of nested groups and routes only runs for the routes they declare
+func NewRouter(mux *http.ServeMux, root RouteGroup) (http.Handler, error) {
+	err := registerGroup(mux, root.Prefix, nil, RouteGroup{Routes: root.Routes, Groups: root.Groups})
+	if err != nil {
+		return nil, err
+	}
+
+	return Chain(mux, root.Middleware...), nil
+}
+
+func registerGroup(mux *http.ServeMux, prefix string, inherited []Middleware, group RouteGroup) error {
+	prefix += group.Prefix
+	// (!) slices.Concat always copies, so sibling groups never share (and overwrite) the same backing array
+	middleware := slices.Concat(inherited, group.Middleware)
+
+	for _, route := range group.Routes {
+		pattern := prefix + route.Path
+		if route.Method != "" {
+			pattern = route.Method + " " + pattern
+		}
+
+		// (!) ServeMux panics on invalid or conflicting patterns, we would rather return an error
+		err := registerRoute(mux, pattern, Chain(route.Handler, slices.Concat(middleware, route.Middleware)...))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, nested := range group.Groups {
+		err := registerGroup(mux, prefix, middleware, nested)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func registerRoute(mux *http.ServeMux, pattern string, handler http.Handler) (err error) {
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			err = fmt.Errorf("registering route %q: %v", strings.TrimSpace(pattern), recovered)
+		}
+	}()
+	mux.Handle(pattern, handler)
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// middlewareTrace appends name to the X-Trace response header
+func middlewareTrace(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	handler := Chain(http.HandlerFunc(handlerReadiness), middlewareTrace("a"), middlewareTrace("b"), middlewareTrace("c"))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+
+	expectedTrace := []string{"a", "b", "c"}
+	if !reflect.DeepEqual(recorder.Header().Values("X-Trace"), expectedTrace) {
+		t.Errorf("expected %v | got %v", expectedTrace, recorder.Header().Values("X-Trace"))
+	}
+}
+
+func TestNewRouter(t *testing.T) {
+	ok := http.HandlerFunc(handlerReadiness)
+
+	handler, err := NewRouter(http.NewServeMux(), RouteGroup{
+		Middleware: []Middleware{middlewareTrace("global")},
+		Groups: []RouteGroup{
+			{
+				Prefix:     "/api",
+				Middleware: []Middleware{middlewareTrace("api")},
+				Routes: []Route{
+					{Method: "GET", Path: "/healthz", Handler: ok},
+					{Method: "POST", Path: "/chirps", Handler: ok, Middleware: []Middleware{middlewareTrace("route")}},
+				},
+				Groups: []RouteGroup{
+					{
+						Prefix:     "/admin",
+						Middleware: []Middleware{middlewareTrace("admin")},
+						Routes: []Route{
+							{Method: "GET", Path: "/metrics", Handler: ok},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		method             string
+		path               string
+		expectedStatusCode int
+		expectedTrace      []string
+	}{
+		{"GET", "/api/healthz", http.StatusOK, []string{"global", "api"}},
+		{"POST", "/api/chirps", http.StatusOK, []string{"global", "api", "route"}},
+		{"GET", "/api/admin/metrics", http.StatusOK, []string{"global", "api", "admin"}},
+		{"POST", "/api/healthz", http.StatusMethodNotAllowed, []string{"global"}},
+		{"GET", "/nowhere", http.StatusNotFound, []string{"global"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(tc.method, tc.path, nil))
+
+			if recorder.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d | got %d", tc.expectedStatusCode, recorder.Code)
+			}
+			if !reflect.DeepEqual(recorder.Header().Values("X-Trace"), tc.expectedTrace) {
+				t.Errorf("expected %v | got %v", tc.expectedTrace, recorder.Header().Values("X-Trace"))
+			}
+		})
+	}
+}
+
+func TestNewRouterConflict(t *testing.T) {
+	ok := http.HandlerFunc(handlerReadiness)
+
+	_, err := NewRouter(http.NewServeMux(), RouteGroup{
+		Routes: []Route{
+			{Method: "GET", Path: "/api/healthz", Handler: ok},
+			{Method: "GET", Path: "/api/healthz", Handler: ok},
+		},
+	})
+	if err == nil || !strings.Contains(err.Error(), "GET /api/healthz") {
+		t.Errorf("expected a conflict error naming the route | got %v", err)
+	}
+}
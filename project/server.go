@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// ErrForcedShutdown is returned by Run when in-flight requests were cut off because they didn't finish in time
+var ErrForcedShutdown = errors.New("in-flight requests did not finish in time")
+
+// Server is the whole Chirpy application as an http.Handler, without any listener
+// Close stops the trash purger and releases the database and the access log once nothing is serving requests anymore
+type Server struct {
+	handler   http.Handler
+	api       *apiConfig
+	accessLog *rotatingFile
+	purger    *trashPurger
+}
+
+// NewServer builds the Chirpy handler from appConfig, logging to slog.Default(), Close it when you're done
+// tests mount it on httptest.NewServer with their own DBPath so they can run in parallel
+func NewServer(appConfig Config) (*Server, error) {
+	return newServer(appConfig, slog.Default())
+}
+
+func newServer(appConfig Config, logger *slog.Logger) (*Server, error) {
+	err := appConfig.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	staticFiles, err := staticFS(appConfig)
+	if err != nil {
+		return nil, err
+	}
+	handlerFileserver, err := newStaticHandler(staticFiles, appConfig.StaticCacheRules, staticOptions{
+		DirListing:   appConfig.StaticDirListing,
+		NotFoundPage: appConfig.StaticNotFoundPage,
+		SPA:          appConfig.StaticSPA,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := openDB(appConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &Server{
+		api: &apiConfig{
+			db:             db,
+			backups:        newBackupManager(db, appConfig),
+			trashRetention: appConfig.TrashRetention.Duration,
+			adminToken:     appConfig.AdminToken,
+		},
+	}
+
+	if appConfig.AccessLogPath != "" {
+		srv.accessLog, err = newRotatingFile(
+			appConfig.AccessLogPath,
+			appConfig.AccessLogMaxSize,
+			appConfig.AccessLogRotateInterval.Duration,
+			appConfig.AccessLogMaxBackups,
+		)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	srv.purger = startTrashPurger(db, appConfig.TrashRetention.Duration, appConfig.TrashPurgeInterval.Duration, logger)
+
+	mux := http.NewServeMux()
+	srv.handler, err = NewRouter(mux, srv.api.routes(mux, appConfig, logger, srv.accessLog, handlerFileserver))
+	if err != nil {
+		srv.Close()
+		return nil, err
+	}
+
+	return srv, nil
+}
+
+// openDB opens the database appConfig points at
+func openDB(appConfig Config) (*DB, error) {
+	keys, err := appConfig.dbKeys()
+	if err != nil {
+		return nil, err
+	}
+	return NewDB(appConfig.DBPath, WithLockTimeout(appConfig.DBLockTimeout.Duration), WithEncryption(keys))
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.handler.ServeHTTP(w, r)
+}
+
+// Close stops the trash purger, closes the database, waiting for any write still holding its lock, and the access log
+func (srv *Server) Close() error {
+	if srv.purger != nil {
+		srv.purger.Stop()
+	}
+	err := srv.api.db.Close()
+	if srv.accessLog != nil {
+		err = errors.Join(err, srv.accessLog.Close())
+	}
+	return err
+}
+
+// Run serves appConfig.Addr (and the HTTP to HTTPS redirect listener when configured) until ctx is done,
+// then drains in-flight requests for up to appConfig.ShutdownTimeout
+// it returns ErrForcedShutdown when the drain timed out and requests were cut off
+func Run(ctx context.Context, appConfig Config) error {
+	listener, err := net.Listen("tcp", appConfig.Addr)
+	if err != nil {
+		return err
+	}
+	return serve(ctx, appConfig, listener)
+}
+
+// serve is Run on a listener that's already open, it always closes listener
+// tests pass a 127.0.0.1:0 listener so they know the address before anything is served
+func serve(ctx context.Context, appConfig Config, listener net.Listener) error {
+	defer listener.Close()
+	logger := slog.Default()
+
+	srv, err := newServer(appConfig, logger)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// (!) after a forced shutdown or a listener error some handlers may still be running,
+		// they get ErrDBClosed and os.ErrClosed, and a write that already holds the database lock finishes first
+		err := srv.Close()
+		if err != nil {
+			logger.Error("closing server", "error", err)
+		}
+	}()
+
+	server := &http.Server{
+		Addr:              appConfig.Addr,
+		Handler:           srv,
+		ReadHeaderTimeout: appConfig.ReadHeaderTimeout.Duration,
+		ReadTimeout:       appConfig.ReadTimeout.Duration,
+		WriteTimeout:      appConfig.WriteTimeout.Duration,
+		IdleTimeout:       appConfig.IdleTimeout.Duration,
+		MaxHeaderBytes:    appConfig.MaxHeaderBytes,
+	}
+
+	configureHTTP2(server, appConfig)
+
+	tlsEnabled := appConfig.TLSCertFile != ""
+	if tlsEnabled {
+		server.TLSConfig, err = newTLSConfig(appConfig, logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the optional plain HTTP listener only redirects to HTTPS
+	var redirectServer *http.Server
+	if tlsEnabled && appConfig.HTTPRedirectAddr != "" {
+		redirectServer = &http.Server{
+			Addr:              appConfig.HTTPRedirectAddr,
+			Handler:           middlewareLog(logger)(handlerRedirectHTTPS(appConfig.Addr)),
+			ReadHeaderTimeout: appConfig.ReadHeaderTimeout.Duration,
+			ReadTimeout:       appConfig.ReadTimeout.Duration,
+			WriteTimeout:      appConfig.WriteTimeout.Duration,
+			IdleTimeout:       appConfig.IdleTimeout.Duration,
+			MaxHeaderBytes:    appConfig.MaxHeaderBytes,
+		}
+	}
+
+	serverErr := make(chan error, 2)
+	go func() {
+		logger.Info("serving files", "from_disk", appConfig.StaticFromDisk, "root", appConfig.StaticRoot, "addr", listener.Addr().String(), "tls", tlsEnabled, "h2c", appConfig.H2C)
+		if tlsEnabled {
+			// (!) the certificate comes from TLSConfig.GetCertificate so no files are passed here
+			serverErr <- server.ServeTLS(listener, "", "")
+			return
+		}
+		serverErr <- server.Serve(listener)
+	}()
+	if redirectServer != nil {
+		go func() {
+			logger.Info("redirecting HTTP to HTTPS", "addr", redirectServer.Addr)
+			serverErr <- redirectServer.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-serverErr:
+		server.Close()
+		if redirectServer != nil {
+			redirectServer.Close()
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	logger.Info("shutting down", "drain_timeout", appConfig.ShutdownTimeout.Duration)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), appConfig.ShutdownTimeout.Duration)
+	defer cancel()
+	if redirectServer != nil {
+		// (!) redirects are instant, there's nothing worth draining
+		redirectServer.Close()
+	}
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		logger.Error("in-flight requests did not finish in time, forcing shutdown", "error", err)
+		server.Close()
+		err = ErrForcedShutdown
+	}
+
+	logger.Info("final metrics", "fileserver_hits", srv.api.fileserverHits.Load(), "panics", srv.api.panics.Load())
+	return err
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewServerInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	appConfig := DefaultConfig()
+	appConfig.DBPath = ""
+
+	_, err := NewServer(appConfig)
+	if err == nil {
+		t.Error("expected an error for an empty db path")
+	}
+}
+
+// startServe runs serve on a fresh 127.0.0.1 listener and waits until /api/healthz answers
+func startServe(t *testing.T, ctx context.Context, appConfig Config) (string, <-chan error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	runErr := make(chan error, 1)
+	go func() {
+		runErr <- serve(ctx, appConfig, listener)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response, err := http.Get("http://" + addr + "/api/healthz")
+		if err == nil {
+			response.Body.Close()
+			if response.StatusCode == http.StatusOK {
+				return addr, runErr
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the server to become ready | got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// holdRequest starts a chirp POST and holds back the body, the handler stays in flight until finish is called
+// (!) the server only answers 100 Continue once the handler reads the body, so it's running when this returns
+func holdRequest(t *testing.T, addr string) (finish func() (*http.Response, error)) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	body := `{"body":"drained"}`
+	_, err = fmt.Fprintf(conn, "POST /api/chirps HTTP/1.1\r\nHost: %s\r\nContent-Type: application/json\r\nContent-Length: %d\r\nExpect: 100-continue\r\n\r\n", addr, len(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusContinue {
+		t.Fatalf("expected status code %d | got %d", http.StatusContinue, response.StatusCode)
+	}
+	return func() (*http.Response, error) {
+		_, err := io.WriteString(conn, body)
+		if err != nil {
+			return nil, err
+		}
+		return http.ReadResponse(reader, nil)
+	}
+}
+
+// waitForDrain waits until the server stopped accepting connections, i.e. the drain has started
+func waitForDrain(t *testing.T, addr string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("expected the server to stop accepting connections")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunShutdown(t *testing.T) {
+	t.Parallel()
+
+	appConfig := newTestConfig(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, runErr := startServe(t, ctx, appConfig)
+	cancel()
+
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("expected a clean shutdown | got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the context was cancelled")
+	}
+}
+
+func TestRunShutdownDrainsInFlightRequests(t *testing.T) {
+	t.Parallel()
+
+	appConfig := newTestConfig(t)
+	appConfig.ShutdownTimeout = Duration{10 * time.Second}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addr, runErr := startServe(t, ctx, appConfig)
+	finish := holdRequest(t, addr)
+
+	cancel()
+	waitForDrain(t, addr)
+	select {
+	case err := <-runErr:
+		t.Fatalf("expected Run to wait for the request in flight | got %v", err)
+	default:
+	}
+
+	response, err := finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusCreated {
+		t.Errorf("expected the request in flight to complete with %d | got %d", http.StatusCreated, response.StatusCode)
+	}
+
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("expected a clean shutdown | got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return once the request in flight completed")
+	}
+}
+
+func TestRunShutdownForced(t *testing.T) {
+	t.Parallel()
+
+	appConfig := newTestConfig(t)
+	appConfig.ShutdownTimeout = Duration{50 * time.Millisecond}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addr, runErr := startServe(t, ctx, appConfig)
+	holdRequest(t, addr)
+
+	cancel()
+	select {
+	case err := <-runErr:
+		if !errors.Is(err, ErrForcedShutdown) {
+			t.Errorf("expected %v | got %v", ErrForcedShutdown, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after the drain timeout")
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheRule sets the Cache-Control of every static file whose path matches Pattern
+type CacheRule struct {
+	Pattern      string `json:"pattern"`
+	CacheControl string `json:"cache_control"`
+}
+
+// CacheRules are tried in order and the first match wins
+// they're written as a JSON array in the config file, flags and environment variables alike
+// because Cache-Control values contain commas
+type CacheRules []CacheRule
+
+// DefaultCacheRules cache fingerprinted files like app.3f2a9c1d.js forever,
+// everything else is revalidated with its ETag on every use
+func DefaultCacheRules() CacheRules {
+	return CacheRules{
+		{Pattern: `\.[0-9a-f]{8,}\.[0-9a-z]+$`, CacheControl: "public, max-age=31536000, immutable"},
+		{Pattern: `.*`, CacheControl: "no-cache"},
+	}
+}
+
+func (rules CacheRules) String() string {
+	byteData, err := json.Marshal(rules)
+	if err != nil {
+		return ""
+	}
+	return string(byteData)
+}
+
+// Set implements flag.Value
+func (rules *CacheRules) Set(s string) error {
+	var parsed CacheRules
+	err := json.Unmarshal([]byte(s), &parsed)
+	if err != nil {
+		return fmt.Errorf("cache rules must be a JSON array like [{\"pattern\": \".*\", \"cache_control\": \"no-cache\"}]: %w", err)
+	}
+	*rules = parsed
+	return nil
+}
+
+// compile checks every pattern is a valid regular expression
+func (rules CacheRules) compile() ([]compiledCacheRule, error) {
+	compiled := make([]compiledCacheRule, 0, len(rules))
+	for _, rule := range rules {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("cache rule %q: %w", rule.Pattern, err)
+		}
+		compiled = append(compiled, compiledCacheRule{pattern: pattern, cacheControl: rule.CacheControl})
+	}
+	return compiled, nil
+}
+
+type compiledCacheRule struct {
+	pattern      *regexp.Regexp
+	cacheControl string
+}
+
+// staticOptions control what happens when a request doesn't map onto a file
+type staticOptions struct {
+	// DirListing lists the contents of directories that have no index.html
+	DirListing bool
+	// NotFoundPage is served with a 404 for missing files, the plain text 404 is used when it's empty
+	NotFoundPage string
+	// SPA serves /index.html for missing paths that don't look like assets (no file extension)
+	// so a single page app can handle its own routes
+	SPA bool
+}
+
+// staticHandler serves the files in fsys with strong ETags, Cache-Control rules
+// and precompressed .gz siblings for clients that accept gzip
+type staticHandler struct {
+	fsys       fs.FS
+	cacheRules []compiledCacheRule
+	options    staticOptions
+
+	// etags caches the hash of every file we've served, keyed by path
+	etags sync.Map
+}
+
+type cachedETag struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+func newStaticHandler(fsys fs.FS, cacheRules CacheRules, options staticOptions) (*staticHandler, error) {
+	compiled, err := cacheRules.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	if options.NotFoundPage != "" {
+		options.NotFoundPage = strings.TrimPrefix(path.Clean("/"+options.NotFoundPage), "/")
+		info, err := fs.Stat(fsys, options.NotFoundPage)
+		if err != nil {
+			return nil, fmt.Errorf("not found page: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return nil, fmt.Errorf("not found page: %s is not a file", options.NotFoundPage)
+		}
+	}
+	if options.SPA {
+		_, err := fs.Stat(fsys, "index.html")
+		if err != nil {
+			return nil, fmt.Errorf("SPA mode needs an index.html: %w", err)
+		}
+	}
+
+	return &staticHandler{
+		fsys:       fsys,
+		cacheRules: compiled,
+		options:    options,
+	}, nil
+}
+
+func (sh *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := r.URL.Path
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+	// (!) like http.FileServer, /index.html is only ever served as its directory
+	if strings.HasSuffix(urlPath, "/index.html") {
+		localRedirect(w, r, "./")
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean(urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+
+	info, err := fs.Stat(sh.fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		sh.serveNotFound(w, r, true)
+		return
+	}
+	if err != nil {
+		sh.serveError(w, r, err)
+		return
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(urlPath, "/") {
+			localRedirect(w, r, path.Base(urlPath)+"/")
+			return
+		}
+		index := path.Join(name, "index.html")
+		if _, err := fs.Stat(sh.fsys, index); err != nil {
+			if !sh.options.DirListing {
+				// (!) the directory exists, but we don't want to admit that
+				sh.serveNotFound(w, r, false)
+				return
+			}
+			// no index.html, fall back to the standard directory listing
+			http.FileServerFS(sh.fsys).ServeHTTP(w, r)
+			return
+		}
+		name = index
+	} else if strings.HasSuffix(urlPath, "/") {
+		// (!) a file asked for as a directory, send the client to the file itself
+		localRedirect(w, r, "../"+path.Base(name))
+		return
+	}
+
+	sh.serveFile(w, r, name)
+}
+
+// serveFile serves name, or name.gz when it exists and the client accepts gzip
+func (sh *staticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	addVary(w.Header(), "Accept-Encoding")
+
+	servedName := name
+	if acceptsEncoding(r.Header.Get("Accept-Encoding"), "gzip") {
+		if info, err := fs.Stat(sh.fsys, name+".gz"); err == nil && info.Mode().IsRegular() {
+			servedName = name + ".gz"
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+	}
+
+	file, err := sh.fsys.Open(servedName)
+	if errors.Is(err, fs.ErrNotExist) {
+		sh.serveNotFound(w, r, false)
+		return
+	}
+	if err != nil {
+		sh.serveError(w, r, err)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		sh.serveError(w, r, err)
+		return
+	}
+
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		byteData, err := io.ReadAll(file)
+		if err != nil {
+			sh.serveError(w, r, err)
+			return
+		}
+		content = bytes.NewReader(byteData)
+	}
+
+	etag, err := sh.etag(servedName, info, content)
+	if err != nil {
+		sh.serveError(w, r, err)
+		return
+	}
+
+	// (!) the type comes from the original name, a .gz sibling would otherwise be sent as application/gzip
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", sh.cacheControl(name))
+
+	// ServeContent takes care of If-None-Match (304s), ranges and HEAD requests
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// etag returns a strong ETag for the file, hashing it only when it's new or has changed on disk
+func (sh *staticHandler) etag(name string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+	cached, ok := sh.etags.Load(name)
+	if ok {
+		entry := cached.(cachedETag)
+		if entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+			return entry.etag, nil
+		}
+	}
+
+	hash := sha256.New()
+	_, err := io.Copy(hash, content)
+	if err != nil {
+		return "", err
+	}
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	etag := `"` + base64.RawURLEncoding.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	sh.etags.Store(name, cachedETag{modTime: info.ModTime(), size: info.Size(), etag: etag})
+	return etag, nil
+}
+
+func (sh *staticHandler) cacheControl(name string) string {
+	for _, rule := range sh.cacheRules {
+		if rule.pattern.MatchString("/" + name) {
+			return rule.cacheControl
+		}
+	}
+	return ""
+}
+
+// serveNotFound answers a request for a file that doesn't exist,
+// spa is false for paths that must never fall back to the app, like existing directories
+func (sh *staticHandler) serveNotFound(w http.ResponseWriter, r *http.Request, spa bool) {
+	if spa && sh.options.SPA && path.Ext(r.URL.Path) == "" {
+		sh.serveFile(w, r, "index.html")
+		return
+	}
+
+	if sh.options.NotFoundPage == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	byteData, err := fs.ReadFile(sh.fsys, sh.options.NotFoundPage)
+	if err != nil {
+		sh.serveError(w, r, err)
+		return
+	}
+	contentType := mime.TypeByExtension(path.Ext(sh.options.NotFoundPage))
+	if contentType == "" {
+		contentType = http.DetectContentType(byteData)
+	}
+	w.Header().Set("Content-Type", contentType)
+	// (!) a 404 must never be cached as if it were the real file
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNotFound)
+	if r.Method != http.MethodHead {
+		w.Write(byteData)
+	}
+}
+
+func (sh *staticHandler) serveError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.NotFound(w, r)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// localRedirect redirects relative to the current path so it keeps working behind StripPrefix
+func localRedirect(w http.ResponseWriter, r *http.Request, target string) {
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	w.Header().Set("Location", target)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
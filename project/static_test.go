@@ -0,0 +1,279 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func newStaticTestHandler(t *testing.T) *staticHandler {
+	t.Helper()
+
+	fsys := fstest.MapFS{
+		"index.html":             {Data: []byte("<h1>Welcome to Chirpy</h1>")},
+		"assets/app.3f2a9c1d.js": {Data: []byte("console.log('chirp')")},
+		"assets/style.css":       {Data: []byte("body { color: red }")},
+		"assets/style.css.gz":    {Data: []byte("pretend this is gzipped")},
+	}
+	handler, err := newStaticHandler(fsys, DefaultCacheRules(), staticOptions{DirListing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestStaticHandler(t *testing.T) {
+	handler := newStaticTestHandler(t)
+
+	testCases := []struct {
+		name                 string
+		path                 string
+		acceptEncoding       string
+		expectedStatusCode   int
+		expectedBody         string
+		expectedContentType  string
+		expectedEncoding     string
+		expectedCacheControl string
+	}{
+		{
+			name:                 "index",
+			path:                 "/",
+			expectedStatusCode:   http.StatusOK,
+			expectedBody:         "<h1>Welcome to Chirpy</h1>",
+			expectedContentType:  "text/html; charset=utf-8",
+			expectedCacheControl: "no-cache",
+		},
+		{
+			name:                 "hashed file is immutable",
+			path:                 "/assets/app.3f2a9c1d.js",
+			expectedStatusCode:   http.StatusOK,
+			expectedBody:         "console.log('chirp')",
+			expectedContentType:  "text/javascript; charset=utf-8",
+			expectedCacheControl: "public, max-age=31536000, immutable",
+		},
+		{
+			name:                 "precompressed sibling",
+			path:                 "/assets/style.css",
+			acceptEncoding:       "gzip, deflate",
+			expectedStatusCode:   http.StatusOK,
+			expectedBody:         "pretend this is gzipped",
+			expectedContentType:  "text/css; charset=utf-8",
+			expectedEncoding:     "gzip",
+			expectedCacheControl: "no-cache",
+		},
+		{
+			name:                 "gzip not accepted",
+			path:                 "/assets/style.css",
+			acceptEncoding:       "deflate",
+			expectedStatusCode:   http.StatusOK,
+			expectedBody:         "body { color: red }",
+			expectedContentType:  "text/css; charset=utf-8",
+			expectedCacheControl: "no-cache",
+		},
+		{
+			name:               "missing file",
+			path:               "/assets/missing.js",
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:               "directory without a slash",
+			path:               "/assets",
+			expectedStatusCode: http.StatusMovedPermanently,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", tc.path, nil)
+			if tc.acceptEncoding != "" {
+				request.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != tc.expectedStatusCode {
+				t.Fatalf("expected status code %d | got %d", tc.expectedStatusCode, recorder.Code)
+			}
+			if tc.expectedStatusCode != http.StatusOK {
+				return
+			}
+
+			if recorder.Body.String() != tc.expectedBody {
+				t.Errorf("expected body %q | got %q", tc.expectedBody, recorder.Body.String())
+			}
+			if recorder.Header().Get("Content-Type") != tc.expectedContentType {
+				t.Errorf("expected Content-Type %s | got %s", tc.expectedContentType, recorder.Header().Get("Content-Type"))
+			}
+			if recorder.Header().Get("Content-Encoding") != tc.expectedEncoding {
+				t.Errorf("expected Content-Encoding %q | got %q", tc.expectedEncoding, recorder.Header().Get("Content-Encoding"))
+			}
+			if recorder.Header().Get("Cache-Control") != tc.expectedCacheControl {
+				t.Errorf("expected Cache-Control %s | got %s", tc.expectedCacheControl, recorder.Header().Get("Cache-Control"))
+			}
+			etag := recorder.Header().Get("ETag")
+			if !strings.HasPrefix(etag, `"`) {
+				t.Errorf("expected a strong ETag | got %s", etag)
+			}
+		})
+	}
+}
+
+func TestStaticHandlerCompressedVary(t *testing.T) {
+	// (!) the compress middleware varies on Accept-Encoding too, the header must not list it twice
+	handler := middlewareCompress(1024)(newStaticTestHandler(t))
+
+	for _, acceptEncoding := range []string{"", "gzip"} {
+		request := httptest.NewRequest("GET", "/assets/style.css", nil)
+		if acceptEncoding != "" {
+			request.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		vary := recorder.Header().Values("Vary")
+		if len(vary) != 1 || vary[0] != "Accept-Encoding" {
+			t.Errorf("Accept-Encoding %q: expected Vary [Accept-Encoding] | got %v", acceptEncoding, vary)
+		}
+	}
+}
+
+func TestStaticHandlerNotModified(t *testing.T) {
+	handler := newStaticTestHandler(t)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/assets/style.css", nil))
+	etag := recorder.Header().Get("ETag")
+
+	request := httptest.NewRequest("GET", "/assets/style.css", nil)
+	request.Header.Set("If-None-Match", etag)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusNotModified {
+		t.Errorf("expected status code %d | got %d", http.StatusNotModified, recorder.Code)
+	}
+	if recorder.Body.Len() != 0 {
+		t.Errorf("expected an empty body | got %q", recorder.Body.String())
+	}
+
+	// the precompressed variant has its own ETag
+	request = httptest.NewRequest("GET", "/assets/style.css", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	request.Header.Set("If-None-Match", etag)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected status code %d | got %d", http.StatusOK, recorder.Code)
+	}
+	if recorder.Header().Get("ETag") == etag {
+		t.Error("expected the gzip variant to have a different ETag")
+	}
+}
+
+func TestCacheRulesSet(t *testing.T) {
+	var rules CacheRules
+	err := rules.Set(`[{"pattern": "\\.png$", "cache_control": "public, max-age=3600"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].CacheControl != "public, max-age=3600" {
+		t.Errorf("unexpected rules %v", rules)
+	}
+
+	err = rules.Set(`.png=public`)
+	if err == nil {
+		t.Error("expected an error for a value that isn't JSON")
+	}
+
+	_, err = CacheRules{{Pattern: "("}}.compile()
+	if err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestStaticHandlerNotFound(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":       {Data: []byte("<h1>Welcome to Chirpy</h1>")},
+		"404.html":         {Data: []byte("<h1>Nothing to see here</h1>")},
+		"assets/logo.png":  {Data: []byte("png")},
+		"assets/style.css": {Data: []byte("body { color: red }")},
+	}
+
+	testCases := []struct {
+		name               string
+		options            staticOptions
+		path               string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			name:               "listing disabled",
+			options:            staticOptions{},
+			path:               "/assets/",
+			expectedStatusCode: http.StatusNotFound,
+			expectedBody:       "404 page not found\n",
+		},
+		{
+			name:               "listing enabled",
+			options:            staticOptions{DirListing: true},
+			path:               "/assets/",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "<a href=\"logo.png\">logo.png</a>",
+		},
+		{
+			name:               "custom 404 page",
+			options:            staticOptions{NotFoundPage: "404.html"},
+			path:               "/missing.js",
+			expectedStatusCode: http.StatusNotFound,
+			expectedBody:       "<h1>Nothing to see here</h1>",
+		},
+		{
+			name:               "custom 404 page hides directories",
+			options:            staticOptions{NotFoundPage: "/404.html", SPA: true},
+			path:               "/assets/",
+			expectedStatusCode: http.StatusNotFound,
+			expectedBody:       "<h1>Nothing to see here</h1>",
+		},
+		{
+			name:               "spa route",
+			options:            staticOptions{NotFoundPage: "404.html", SPA: true},
+			path:               "/chirps/42",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "<h1>Welcome to Chirpy</h1>",
+		},
+		{
+			name:               "spa missing asset",
+			options:            staticOptions{NotFoundPage: "404.html", SPA: true},
+			path:               "/assets/missing.js",
+			expectedStatusCode: http.StatusNotFound,
+			expectedBody:       "<h1>Nothing to see here</h1>",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, err := newStaticHandler(fsys, DefaultCacheRules(), tc.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", tc.path, nil))
+
+			if recorder.Code != tc.expectedStatusCode {
+				t.Errorf("expected status code %d | got %d", tc.expectedStatusCode, recorder.Code)
+			}
+			if !strings.Contains(recorder.Body.String(), tc.expectedBody) {
+				t.Errorf("expected body to contain %q | got %q", tc.expectedBody, recorder.Body.String())
+			}
+		})
+	}
+
+	_, err := newStaticHandler(fsys, DefaultCacheRules(), staticOptions{NotFoundPage: "missing.html"})
+	if err == nil {
+		t.Error("expected an error for a missing not found page")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// embeddedStatic is what /app serves by default, so the server never depends on its working directory
+// (!) embed skips files starting with "." or "_" in the embedded directories
+//
+//go:embed index.html assets
+var embeddedStatic embed.FS
+
+// staticFS returns the files served under /app,
+// the embedded copy unless appConfig.StaticFromDisk asks for StaticRoot (handy while developing)
+func staticFS(appConfig Config) (fs.FS, error) {
+	if !appConfig.StaticFromDisk {
+		return hiddenFS{fsys: embeddedStatic}, nil
+	}
+
+	root, err := filepath.Abs(appConfig.StaticRoot)
+	if err != nil {
+		return nil, err
+	}
+	// (!) the database, its backups, the access log, the config file and the TLS key pair must never be downloadable,
+	// even when someone points -static-root at the working directory
+	hidden := []string{}
+	for _, path := range []string{
+		appConfig.DBPath, appConfig.BackupDir, appConfig.AccessLogPath, appConfig.ConfigPath,
+		appConfig.TLSCertFile, appConfig.TLSKeyFile,
+	} {
+		if path == "" {
+			continue
+		}
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			hidden = append(hidden, filepath.ToSlash(rel))
+		}
+	}
+
+	return hiddenFS{fsys: os.DirFS(appConfig.StaticRoot), hidden: hidden}, nil
+}
+
+// hiddenFS pretends dotfiles (.env, .git/...) and the hidden paths don't exist
+// a hidden path also hides everything below it and every file that starts with it,
+// e.g. "database.json" hides "database.json.tmp-123"
+type hiddenFS struct {
+	fsys   fs.FS
+	hidden []string
+}
+
+func (hfs hiddenFS) isHidden(name string) bool {
+	for _, element := range strings.Split(name, "/") {
+		if strings.HasPrefix(element, ".") && element != "." {
+			return true
+		}
+	}
+	for _, hidden := range hfs.hidden {
+		if hidden == "." || name == hidden || strings.HasPrefix(name, hidden+".") || strings.HasPrefix(name, hidden+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (hfs hiddenFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) || hfs.isHidden(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	file, err := hfs.fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// (!) directory listings must not show what we're hiding either
+	if dir, ok := file.(fs.ReadDirFile); ok {
+		return hiddenDir{ReadDirFile: dir, hfs: hfs, name: name}, nil
+	}
+	return file, nil
+}
+
+type hiddenDir struct {
+	fs.ReadDirFile
+	hfs  hiddenFS
+	name string
+}
+
+// ReadDir filters the hidden entries out
+// (!) with n > 0 an empty slice means the end of the directory to callers, so a batch that was
+// all hidden is followed by the next one until there's something to return or io.EOF
+func (hd hiddenDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	for {
+		entries, err := hd.ReadDirFile.ReadDir(n)
+		visible := entries[:0]
+		for _, entry := range entries {
+			if !hd.hfs.isHidden(path.Join(hd.name, entry.Name())) {
+				visible = append(visible, entry)
+			}
+		}
+		if n <= 0 || len(visible) > 0 || err != nil {
+			return visible, err
+		}
+	}
+}
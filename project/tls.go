@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often a handshake may trigger a look at the certificate files on disk
+const certCheckInterval = time.Second
+
+// tlsVersions maps the config values to crypto/tls versions
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// intermediateCipherSuites follows the Mozilla "intermediate" recommendation for TLS 1.2,
+// forward secret AEAD suites only - TLS 1.3 suites are not configurable in Go
+var intermediateCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// newTLSConfig builds the server TLS config, the certificate is reloaded from disk whenever it changes
+func newTLSConfig(appConfig Config, logger *slog.Logger) (*tls.Config, error) {
+	reloader, err := newCertReloader(appConfig.TLSCertFile, appConfig.TLSKeyFile, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tlsVersions[appConfig.TLSMinVersion],
+		GetCertificate: reloader.GetCertificate,
+	}
+	if appConfig.TLSCipherPolicy == "intermediate" {
+		tlsConfig.CipherSuites = intermediateCipherSuites
+	}
+	return tlsConfig, nil
+}
+
+// certReloader serves a certificate and key pair and picks up new files without a restart
+// e.g. after certbot or cert-manager renews them
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	mux         sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	reloader := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logger,
+	}
+	err := reloader.reload()
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mux.Lock()
+	defer cr.mux.Unlock()
+
+	if time.Since(cr.lastCheck) >= certCheckInterval {
+		cr.lastCheck = time.Now()
+		if cr.changed() {
+			// (!) a half written renewal must not take the server down, keep serving the old certificate
+			err := cr.reloadLocked()
+			if err != nil {
+				cr.logger.Error("reloading TLS certificate, keeping the previous one", "error", err)
+			} else {
+				cr.logger.Info("reloaded TLS certificate", "cert_file", cr.certFile)
+			}
+		}
+	}
+
+	return cr.cert, nil
+}
+
+func (cr *certReloader) reload() error {
+	cr.mux.Lock()
+	defer cr.mux.Unlock()
+	return cr.reloadLocked()
+}
+
+func (cr *certReloader) reloadLocked() error {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return err
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading TLS key pair: %w", err)
+	}
+
+	cr.cert = &cert
+	cr.certModTime = certInfo.ModTime()
+	cr.keyModTime = keyInfo.ModTime()
+	return nil
+}
+
+func (cr *certReloader) changed() bool {
+	certInfo, err := os.Stat(cr.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(cr.keyFile)
+	if err != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(cr.certModTime) || !keyInfo.ModTime().Equal(cr.keyModTime)
+}
+
+// handlerRedirectHTTPS sends plain HTTP clients to the same URL on the HTTPS listener at httpsAddr
+func handlerRedirectHTTPS(httpsAddr string) http.Handler {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		// (!) only GET and HEAD may be safely redirected with a 301, everything else keeps its method with a 308
+		code := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			code = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), code)
+	})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a fresh certificate for commonName to certFile and keyFile
+func writeSelfSignedCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile, "first.example.com")
+
+	reloader, err := newCertReloader(certFile, keyFile, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commonName := func() string {
+		t.Helper()
+		cert, err := reloader.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return leaf.Subject.CommonName
+	}
+
+	if commonName() != "first.example.com" {
+		t.Fatalf("expected %s | got %s", "first.example.com", commonName())
+	}
+
+	writeSelfSignedCert(t, certFile, keyFile, "second.example.com")
+	// (!) make sure the modification time changes even on filesystems with coarse timestamps
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+	reloader.lastCheck = time.Time{}
+
+	if commonName() != "second.example.com" {
+		t.Errorf("expected %s | got %s", "second.example.com", commonName())
+	}
+
+	// a broken renewal keeps the previous certificate
+	os.WriteFile(certFile, []byte("not a certificate"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	reloader.lastCheck = time.Time{}
+
+	if commonName() != "second.example.com" {
+		t.Errorf("expected %s | got %s", "second.example.com", commonName())
+	}
+}
+
+func TestHTTPSServing(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile, "localhost")
+
+	appConfig := DefaultConfig()
+	appConfig.TLSCertFile = certFile
+	appConfig.TLSKeyFile = keyFile
+	appConfig.TLSMinVersion = "1.3"
+
+	tlsConfig, err := newTLSConfig(appConfig, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(handlerReadiness))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	// (!) server.Client() trusts httptest's own certificate, not ours
+	client := server.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+
+	response, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if response.TLS == nil || response.TLS.Version < tls.VersionTLS13 {
+		t.Errorf("expected a TLS 1.3 connection | got %+v", response.TLS)
+	}
+	body, _ := io.ReadAll(response.Body)
+	if !bytes.Equal(body, []byte("OK")) {
+		t.Errorf("expected %s | got %s", "OK", body)
+	}
+}
+
+func TestHandlerRedirectHTTPS(t *testing.T) {
+	testCases := []struct {
+		name             string
+		httpsAddr        string
+		method           string
+		target           string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{
+			name:             "default https port",
+			httpsAddr:        ":443",
+			method:           "GET",
+			target:           "http://chirpy.example.com:8000/app/?x=1",
+			expectedCode:     http.StatusMovedPermanently,
+			expectedLocation: "https://chirpy.example.com/app/?x=1",
+		},
+		{
+			name:             "custom https port keeps the method",
+			httpsAddr:        ":8443",
+			method:           "POST",
+			target:           "http://chirpy.example.com/api/chirps",
+			expectedCode:     http.StatusPermanentRedirect,
+			expectedLocation: "https://chirpy.example.com:8443/api/chirps",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handlerRedirectHTTPS(tc.httpsAddr).ServeHTTP(recorder, httptest.NewRequest(tc.method, tc.target, nil))
+
+			if recorder.Code != tc.expectedCode {
+				t.Errorf("expected status code %d | got %d", tc.expectedCode, recorder.Code)
+			}
+			if recorder.Header().Get("Location") != tc.expectedLocation {
+				t.Errorf("expected location %s | got %s", tc.expectedLocation, recorder.Header().Get("Location"))
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrChirpNotFound is returned for a chirp that doesn't exist, or that is in the trash when it shouldn't be
+var ErrChirpNotFound = errors.New("chirp not found")
+
+// ErrChirpExpired is returned when restoring a chirp that has been in the trash for longer than the retention
+var ErrChirpExpired = errors.New("chirp has been in the trash for too long to be restored")
+
+// nextChirpID hands out the id of a new chirp and moves the high-water mark past it
+// (!) ids are never reused, not even the ones of purged chirps: an old link or a pending restore
+// must not land on an unrelated chirp
+func (dbStructure *DBStructure) nextChirpID() int {
+	id := max(dbStructure.NextChirpID, 1)
+	// an id that's taken means the high-water mark is behind, which fsck reports, skip rather than overwrite
+	for {
+		if _, taken := dbStructure.Chirps[id]; !taken {
+			break
+		}
+		id++
+	}
+	dbStructure.NextChirpID = id + 1
+	return id
+}
+
+// DeleteChirp moves a chirp to the trash, it's hidden from GetChirps until it's restored or purged
+func (db *DB) DeleteChirp(id int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	err := db.fileLock.Lock(db.lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer db.fileLock.Unlock()
+
+	dbMemory, err := db.loadDB()
+	if err != nil {
+		return err
+	}
+	chirp, ok := dbMemory.Chirps[id]
+	if !ok || chirp.DeletedAt != nil {
+		return ErrChirpNotFound
+	}
+	deletedAt := time.Now().UTC()
+	chirp.DeletedAt = &deletedAt
+	dbMemory.Chirps[id] = chirp
+	return db.writeDB(dbMemory)
+}
+
+// RestoreChirp takes a chirp out of the trash if it was deleted after deletedAfter
+// restoring a chirp that isn't in the trash does nothing
+func (db *DB) RestoreChirp(id int, deletedAfter time.Time) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.closed {
+		return Chirp{}, ErrDBClosed
+	}
+
+	err := db.fileLock.Lock(db.lockTimeout)
+	if err != nil {
+		return Chirp{}, err
+	}
+	defer db.fileLock.Unlock()
+
+	dbMemory, err := db.loadDB()
+	if err != nil {
+		return Chirp{}, err
+	}
+	chirp, ok := dbMemory.Chirps[id]
+	if !ok {
+		return Chirp{}, ErrChirpNotFound
+	}
+	if chirp.DeletedAt == nil {
+		return chirp, nil
+	}
+	// (!) the purger may not have caught up yet, but past the retention a chirp counts as gone
+	if !chirp.DeletedAt.After(deletedAfter) {
+		return Chirp{}, ErrChirpExpired
+	}
+	chirp.DeletedAt = nil
+	dbMemory.Chirps[id] = chirp
+	err = db.writeDB(dbMemory)
+	if err != nil {
+		return Chirp{}, err
+	}
+	return chirp, nil
+}
+
+// PurgeChirps permanently removes the chirps deleted at or before deletedBefore and returns how many there were
+func (db *DB) PurgeChirps(deletedBefore time.Time) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if db.closed {
+		return 0, ErrDBClosed
+	}
+
+	err := db.fileLock.Lock(db.lockTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer db.fileLock.Unlock()
+
+	dbMemory, err := db.loadDB()
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for id, chirp := range dbMemory.Chirps {
+		if chirp.DeletedAt != nil && !chirp.DeletedAt.After(deletedBefore) {
+			delete(dbMemory.Chirps, id)
+			purged++
+		}
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	return purged, db.writeDB(dbMemory)
+}
+
+// trashPurger permanently removes the chirps that have been in the trash for longer than the retention,
+// once when it starts and then every interval
+type trashPurger struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func startTrashPurger(db *DB, retention, interval time.Duration, logger *slog.Logger) *trashPurger {
+	ctx, cancel := context.WithCancel(context.Background())
+	tp := &trashPurger{cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(tp.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			purged, err := db.PurgeChirps(time.Now().Add(-retention))
+			if err != nil {
+				// (!) a locked or closed database is retried on the next tick
+				logger.Error("purging the trash", "error", err)
+			} else if purged > 0 {
+				logger.Info("purged the trash", "chirps", purged, "retention", retention)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return tp
+}
+
+// Stop waits for a purge that's already running to finish
+func (tp *trashPurger) Stop() {
+	tp.cancel()
+	<-tp.done
+}
+
+func chirpIDFromRequest(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil || id < 1 {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid chirp id %q", r.PathValue("chirpID")))
+		return 0, false
+	}
+	return id, true
+}
+
+// handlerChirpsDelete moves a chirp to the trash, POST /api/chirps/{chirpID}/restore brings it back
+func (cfg *apiConfig) handlerChirpsDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := chirpIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := cfg.db.DeleteChirp(id)
+	if errors.Is(err, ErrChirpNotFound) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	if err != nil {
+		log.Printf("error deleting chirp %d: %s", id, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete chirp")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlerChirpsRestore takes a chirp out of the trash within the retention window
+func (cfg *apiConfig) handlerChirpsRestore(w http.ResponseWriter, r *http.Request) {
+	id, ok := chirpIDFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	chirp, err := cfg.db.RestoreChirp(id, time.Now().Add(-cfg.trashRetention))
+	if errors.Is(err, ErrChirpNotFound) {
+		respondWithError(w, http.StatusNotFound, "Chirp not found")
+		return
+	}
+	if errors.Is(err, ErrChirpExpired) {
+		respondWithError(w, http.StatusGone, fmt.Sprintf("Chirp was deleted more than %s ago", cfg.trashRetention))
+		return
+	}
+	if err != nil {
+		log.Printf("error restoring chirp %d: %s", id, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't restore chirp")
+		return
+	}
+	respondWithJSON(w, http.StatusOK, chirp)
+}
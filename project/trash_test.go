@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDBTrash(t *testing.T) {
+	t.Parallel()
+
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.CreateChirps([]string{"keep", "delete", "last"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.DeleteChirp(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DeleteChirp(2)
+	if !errors.Is(err, ErrChirpNotFound) {
+		t.Errorf("expected %v deleting twice | got %v", ErrChirpNotFound, err)
+	}
+	err = db.DeleteChirp(42)
+	if !errors.Is(err, ErrChirpNotFound) {
+		t.Errorf("expected %v | got %v", ErrChirpNotFound, err)
+	}
+
+	chirps, err := db.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Chirp{{ID: 1, Body: "keep"}, {ID: 3, Body: "last"}}
+	if !reflect.DeepEqual(chirps, expected) {
+		t.Errorf("expected the trash to be hidden %v | got %v", expected, chirps)
+	}
+
+	_, err = db.RestoreChirp(2, time.Now())
+	if !errors.Is(err, ErrChirpExpired) {
+		t.Errorf("expected %v past the retention | got %v", ErrChirpExpired, err)
+	}
+	restored, err := db.RestoreChirp(2, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored, Chirp{ID: 2, Body: "delete"}) {
+		t.Errorf("expected the restored chirp | got %v", restored)
+	}
+	_, err = db.RestoreChirp(42, time.Now().Add(-time.Hour))
+	if !errors.Is(err, ErrChirpNotFound) {
+		t.Errorf("expected %v | got %v", ErrChirpNotFound, err)
+	}
+
+	err = db.DeleteChirp(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	purged, err := db.PurgeChirps(time.Now().Add(-time.Hour))
+	if err != nil || purged != 0 {
+		t.Errorf("expected nothing to purge within the retention | got %d, %v", purged, err)
+	}
+	purged, err = db.PurgeChirps(time.Now())
+	if err != nil || purged != 1 {
+		t.Errorf("expected 1 chirp purged | got %d, %v", purged, err)
+	}
+	_, err = db.RestoreChirp(3, time.Time{})
+	if !errors.Is(err, ErrChirpNotFound) {
+		t.Errorf("expected %v after the purge | got %v", ErrChirpNotFound, err)
+	}
+
+	chirp, err := db.CreateChirp("after the purge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chirp.ID != 4 {
+		t.Errorf("expected the id of the purged chirp not to be reused, id 4 | got %d", chirp.ID)
+	}
+	err = db.DeleteChirp(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.PurgeChirps(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err = db.CreateChirp("no duplicates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chirp.ID != 5 {
+		t.Errorf("expected a gap to leave the ids in use alone, id 5 | got %d", chirp.ID)
+	}
+}
+
+func TestHandlerChirpsDeleteRestore(t *testing.T) {
+	t.Parallel()
+
+	appConfig := newTestConfig(t)
+	testServer := newTestServer(t, appConfig)
+	client := testServer.Client()
+
+	response, err := client.Post(testServer.URL+"/api/chirps", "application/json", strings.NewReader(`{"body":"oops"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	do := func(method, path, token string) (*http.Response, string) {
+		t.Helper()
+		request, err := http.NewRequest(method, testServer.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		body, err := io.ReadAll(response.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return response, string(body)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{name: "delete without token", method: "DELETE", path: "/api/chirps/1", status: http.StatusUnauthorized},
+		{name: "restore without token", method: "POST", path: "/api/chirps/1/restore", status: http.StatusUnauthorized},
+		{name: "delete invalid id", method: "DELETE", path: "/api/chirps/abc", token: testAdminToken, status: http.StatusBadRequest},
+		{name: "delete missing", method: "DELETE", path: "/api/chirps/42", token: testAdminToken, status: http.StatusNotFound},
+		{name: "delete", method: "DELETE", path: "/api/chirps/1", token: testAdminToken, status: http.StatusNoContent},
+		{name: "delete again", method: "DELETE", path: "/api/chirps/1", token: testAdminToken, status: http.StatusNotFound},
+		{name: "list hides the trash", method: "GET", path: "/api/chirps", status: http.StatusOK},
+		{name: "restore missing", method: "POST", path: "/api/chirps/42/restore", token: testAdminToken, status: http.StatusNotFound},
+		{name: "restore", method: "POST", path: "/api/chirps/1/restore", token: testAdminToken, status: http.StatusOK},
+	} {
+		response, body := do(tc.method, tc.path, tc.token)
+		if response.StatusCode != tc.status {
+			t.Errorf("%s: expected status code %d | got %d %s", tc.name, tc.status, response.StatusCode, body)
+		}
+		if tc.name == "list hides the trash" && strings.TrimSpace(body) != "[]" {
+			t.Errorf("%s: expected [] | got %s", tc.name, body)
+		}
+		if tc.name == "restore" {
+			chirp := Chirp{}
+			err := json.Unmarshal([]byte(body), &chirp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(chirp, Chirp{ID: 1, Body: "oops"}) {
+				t.Errorf("%s: expected the restored chirp | got %s", tc.name, body)
+			}
+		}
+	}
+}
+
+func TestHandlerChirpsDeleteDefaultConfig(t *testing.T) {
+	t.Parallel()
+
+	// (!) DefaultConfig has no admin token, deleting must not be open to anyone
+	appConfig := newTestConfig(t)
+	appConfig.AdminToken = ""
+	testServer := newTestServer(t, appConfig)
+	client := testServer.Client()
+
+	response, err := client.Post(testServer.URL+"/api/chirps", "application/json", strings.NewReader(`{"body":"safe"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	for _, route := range []string{"DELETE /api/chirps/1", "POST /api/chirps/1/restore"} {
+		method, path, _ := strings.Cut(route, " ")
+		for _, authorization := range []string{"", "Bearer ", "Bearer anything"} {
+			request, err := http.NewRequest(method, testServer.URL+path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if authorization != "" {
+				request.Header.Set("Authorization", authorization)
+			}
+			response, err := client.Do(request)
+			if err != nil {
+				t.Fatal(err)
+			}
+			response.Body.Close()
+			if response.StatusCode != http.StatusForbidden {
+				t.Errorf("%s %s with %q: expected status code %d | got %d", method, path, authorization, http.StatusForbidden, response.StatusCode)
+			}
+		}
+	}
+
+	response, err = client.Get(testServer.URL + "/api/chirps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	chirps := []Chirp{}
+	err = json.NewDecoder(response.Body).Decode(&chirps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(chirps, []Chirp{{ID: 1, Body: "safe"}}) {
+		t.Errorf("expected the chirp to survive | got %v", chirps)
+	}
+}
+
+func TestHandlerChirpsRestoreExpired(t *testing.T) {
+	t.Parallel()
+
+	appConfig := newTestConfig(t)
+	appConfig.TrashRetention = Duration{time.Nanosecond}
+	// (!) the purger runs once when the server starts, the next run is too far off to get to the chirp first
+	appConfig.TrashPurgeInterval = Duration{time.Hour}
+	testServer := newTestServer(t, appConfig)
+	client := testServer.Client()
+
+	response, err := client.Post(testServer.URL+"/api/chirps", "application/json", strings.NewReader(`{"body":"gone"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	response = adminRequest(t, client, "DELETE", testServer.URL+"/api/chirps/1")
+	response.Body.Close()
+
+	time.Sleep(time.Millisecond)
+	response = adminRequest(t, client, "POST", testServer.URL+"/api/chirps/1/restore")
+	response.Body.Close()
+	if response.StatusCode != http.StatusGone {
+		t.Errorf("expected status code %d | got %d", http.StatusGone, response.StatusCode)
+	}
+}
+
+func TestTrashPurger(t *testing.T) {
+	t.Parallel()
+
+	db, err := NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.CreateChirps([]string{"keep", "purge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DeleteChirp(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	purger := startTrashPurger(db, time.Nanosecond, 10*time.Millisecond, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer purger.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		// (!) past the retention RestoreChirp only reports whether the chirp is still there
+		_, err := db.RestoreChirp(2, time.Now())
+		if errors.Is(err, ErrChirpNotFound) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the purger to remove chirp 2 | got %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	chirps, err := db.GetChirps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(chirps, []Chirp{{ID: 1, Body: "keep"}}) {
+		t.Errorf("expected only the chirp outside the trash to be left | got %v", chirps)
+	}
+}